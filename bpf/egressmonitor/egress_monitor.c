@@ -0,0 +1,95 @@
+// bpf/egressmonitor/egress_monitor.c
+#include "vmlinux.h"
+
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+#define ETH_HLEN 14
+#define ETH_P_IP 0x0800
+#define TC_ACT_UNSPEC -1
+
+/*
+ * Attached to the ingress hook of the host side of each pod veth: traffic
+ * the pod sends leaves its eth0 and enters the host through the peer, so
+ * "ingress on the host veth" is "egress of the pod".
+ */
+
+/* bucket 0 is reserved for destinations that match no configured CIDR */
+struct egress_key {
+    __u32 ifindex;
+    __u32 bucket;
+};
+
+struct egress_value {
+    __u64 bytes;
+    __u64 packets;
+};
+
+struct cidr_key {
+    __u32 prefixlen;
+    __u8  addr[4];
+};
+
+#ifndef EGRESS_CIDR_MAX_ENTRIES
+#define EGRESS_CIDR_MAX_ENTRIES 256
+#endif
+
+#ifndef EGRESS_STATS_MAX_ENTRIES
+#define EGRESS_STATS_MAX_ENTRIES 16384
+#endif
+
+/* destination CIDR -> bucket id, populated from userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, EGRESS_CIDR_MAX_ENTRIES);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct cidr_key);
+    __type(value, __u32);
+} egress_cidrs SEC(".maps");
+
+/* per-cpu byte/packet counters, drained by userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_HASH);
+    __uint(max_entries, EGRESS_STATS_MAX_ENTRIES);
+    __type(key, struct egress_key);
+    __type(value, struct egress_value);
+} egress_stats SEC(".maps");
+
+SEC("tc")
+int tc__egress_account(struct __sk_buff *skb)
+{
+    struct egress_key key = {};
+    struct cidr_key lpm = {};
+    struct egress_value *val;
+    __u32 *bucket;
+
+    if (skb->protocol != bpf_htons(ETH_P_IP))
+        return TC_ACT_UNSPEC;
+
+    /* iphdr->daddr sits 16 bytes into the IPv4 header */
+    if (bpf_skb_load_bytes(skb, ETH_HLEN + 16, lpm.addr, sizeof(lpm.addr)) < 0)
+        return TC_ACT_UNSPEC;
+
+    lpm.prefixlen = 32;
+    bucket = bpf_map_lookup_elem(&egress_cidrs, &lpm);
+
+    key.ifindex = skb->ifindex;
+    key.bucket = bucket ? *bucket : 0;
+
+    val = bpf_map_lookup_elem(&egress_stats, &key);
+    if (!val) {
+        struct egress_value zero = {};
+        bpf_map_update_elem(&egress_stats, &key, &zero, BPF_NOEXIST);
+        val = bpf_map_lookup_elem(&egress_stats, &key);
+        if (!val)
+            return TC_ACT_UNSPEC;
+    }
+
+    /* per-cpu value: no atomics needed */
+    val->bytes += skb->len;
+    val->packets += 1;
+
+    return TC_ACT_UNSPEC;
+}
+
+char LICENSE[] SEC("license") = "GPL";
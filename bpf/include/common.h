@@ -2,6 +2,7 @@
 #define __COMMON_H
 #include "vmlinux.h"
 #include <bpf/bpf_helpers.h>
+#include "event.h"
 
 struct flow_key_t {
     __u32 netns;
@@ -18,11 +19,18 @@ struct flow_key_t {
 #endif
 
 
+/* the process that opened a flow, recorded where it runs in its context */
+struct flow_owner {
+    __u32 pid;
+    __u32 _pad;
+    __u64 cgroup;
+};
+
 struct {
     __uint(type, BPF_MAP_TYPE_LRU_HASH);
     __uint(max_entries, FLOW_PID_MAP_MAX_ENTRIES);     // up to 128k flows
     __type(key, struct flow_key_t);
-    __type(value, pid_t);
+    __type(value, struct flow_owner);
 } flow_pid_map SEC(".maps");
 
 #endif /* __COMMON_H */
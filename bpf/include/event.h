@@ -0,0 +1,54 @@
+#ifndef __EVENT_H
+#define __EVENT_H
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+
+/*
+ * Every event a module sends to userspace starts with struct
+ * flow_event_header (vmlinux.h already has an event_header, so names here
+ * carry the flow_ prefix). Compatibility rules, so agents and objects of different versions can be
+ * mixed:
+ *
+ *  - Header fields are only ever appended. version is bumped when they
+ *    are and hdr_len says where the payload starts, so an older reader
+ *    skips fields it does not know.
+ *  - A payload is identified by (module, type). Its fields are only ever
+ *    appended too; readers ignore trailing bytes they do not know. A
+ *    change that cannot be made by appending needs a new type.
+ *  - len covers header and payload; readers skip events whose
+ *    (module, type) they have no decoder for.
+ *
+ * Mirrored by common.EventHeader in Go.
+ */
+#define EVENT_SCHEMA_VERSION 1
+
+enum event_module {
+    MODULE_TCPMONITOR = 1,
+    MODULE_PROCTRACKER = 2,
+};
+
+struct flow_event_header {
+    __u8  version;
+    __u8  hdr_len;
+    __u8  module;
+    __u8  _pad;
+    __u16 type;
+    __u16 len;
+    __u64 timestamp;
+    __u64 cgroup;             // cgroup id of pid, 0 when unknown
+    __u32 netns;
+    __u32 pid;
+};
+
+static __always_inline void init_event_header(struct flow_event_header *hdr,
+                                              __u8 module, __u16 type, __u16 len)
+{
+    hdr->version = EVENT_SCHEMA_VERSION;
+    hdr->hdr_len = sizeof(*hdr);
+    hdr->module = module;
+    hdr->type = type;
+    hdr->len = len;
+    hdr->timestamp = bpf_ktime_get_ns();
+}
+
+#endif /* __EVENT_H */
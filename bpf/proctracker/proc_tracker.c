@@ -0,0 +1,124 @@
+// bpf/proctracker/proc_tracker.c
+#include "vmlinux.h"
+
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#include "event.h"
+
+#define PROC_EVENT_FORK 1
+#define PROC_EVENT_EXEC 2
+#define PROC_EVENT_EXIT 3
+
+#define TASK_COMM_LEN 16
+
+/*
+ * Event sent to userspace: the common header (module MODULE_PROCTRACKER,
+ * type PROC_EVENT_*, pid the tgid) followed by the payload. New payload
+ * fields go at the end, see event.h.
+ */
+struct flow_proc_event {
+    struct flow_event_header hdr;
+    __u32 ppid;               /* parent tgid, fork only */
+    char  comm[TASK_COMM_LEN];
+    __u32 _pad;
+};
+
+/*
+ * User-space events. A ring buffer is shared by all CPUs and read in
+ * reservation order, so the fork of a PID always comes before its exit;
+ * per-CPU perf buffers give no such order.
+ */
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} proc_events SEC(".maps");
+
+/* events lost because the ring buffer was full, read by userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} ringbuf_drops SEC(".maps");
+
+#ifndef PID_CGROUP_MAX_ENTRIES
+#define PID_CGROUP_MAX_ENTRIES 65536
+#endif
+
+/* tgid -> cgroup id of every live process, read by the cgroup enricher */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, PID_CGROUP_MAX_ENTRIES);
+    __type(key, __u32);
+    __type(value, __u64);
+} pid_cgroup SEC(".maps");
+
+/* network namespace inode of task, 0 once it has left its namespaces */
+static __always_inline __u32 task_netns(struct task_struct *task)
+{
+    struct nsproxy *nsproxy = task->nsproxy;
+
+    if (!nsproxy || !nsproxy->net_ns)
+        return 0;
+    return nsproxy->net_ns->ns.inum;
+}
+
+static __always_inline void emit(struct task_struct *task, __u16 type, __u32 pid, __u32 ppid, __u64 cgroup_id)
+{
+    struct flow_proc_event evt = {};
+
+    if (type == PROC_EVENT_EXIT)
+        bpf_map_delete_elem(&pid_cgroup, &pid);
+    else
+        bpf_map_update_elem(&pid_cgroup, &pid, &cgroup_id, BPF_ANY);
+
+    init_event_header(&evt.hdr, MODULE_PROCTRACKER, type, sizeof(evt));
+    evt.hdr.cgroup = cgroup_id;
+    evt.hdr.netns = task_netns(task);
+    evt.hdr.pid = pid;
+    evt.ppid = ppid;
+    bpf_get_current_comm(&evt.comm, sizeof(evt.comm));
+
+    if (bpf_ringbuf_output(&proc_events, &evt, sizeof(evt), 0)) {
+        __u32 zero = 0;
+        __u64 *drops = bpf_map_lookup_elem(&ringbuf_drops, &zero);
+        if (drops)
+            (*drops)++;
+    }
+}
+
+SEC("tp_btf/sched_process_fork")
+int BPF_PROG(tp_btf__sched_process_fork, struct task_struct *parent, struct task_struct *child)
+{
+    /* new threads share the process entry */
+    if (child->pid != child->tgid)
+        return 0;
+
+    /* the child inherits the cgroup of the forking task */
+    __u64 cgroup_id = bpf_get_current_cgroup_id();
+
+    emit(child, PROC_EVENT_FORK, child->tgid, parent->tgid, cgroup_id);
+    return 0;
+}
+
+SEC("tp_btf/sched_process_exec")
+int BPF_PROG(tp_btf__sched_process_exec, struct task_struct *p, pid_t old_pid, struct linux_binprm *bprm)
+{
+    emit(p, PROC_EVENT_EXEC, p->tgid, 0, bpf_get_current_cgroup_id());
+    return 0;
+}
+
+SEC("tp_btf/sched_process_exit")
+int BPF_PROG(tp_btf__sched_process_exit, struct task_struct *p)
+{
+    /* exiting threads other than the group leader do not end the process */
+    if (p->pid != p->tgid)
+        return 0;
+
+    /* the exiting task is current, so its cgroup is still at hand */
+    emit(p, PROC_EVENT_EXIT, p->tgid, 0, bpf_get_current_cgroup_id());
+    return 0;
+}
+
+char LICENSE[] SEC("license") = "GPL";
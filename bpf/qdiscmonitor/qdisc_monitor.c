@@ -0,0 +1,97 @@
+// bpf/qdiscmonitor/qdisc_monitor.c
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+
+/* net_dev_xmit rc returned by a driver whose ring is full */
+#define NETDEV_TX_BUSY 0x10
+
+#define QDISC_EVENT_DROP    1
+#define QDISC_EVENT_REQUEUE 2
+
+/*
+ * Inode of the host network namespace, set by the loader. Tracepoints fire
+ * in every namespace and a pod's eth0 has an ifindex of its own netns, so
+ * only devices of the host namespace are counted (0 counts all).
+ */
+const volatile __u32 host_netns = 0;
+
+struct qdisc_key {
+    __u32 ifindex;
+    __u32 kind;
+    __u32 netns;
+};
+
+#ifndef QDISC_STATS_MAX_ENTRIES
+#define QDISC_STATS_MAX_ENTRIES 16384
+#endif
+
+/* per-cpu drop/requeue counters, drained by userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_HASH);
+    __uint(max_entries, QDISC_STATS_MAX_ENTRIES);
+    __type(key, struct qdisc_key);
+    __type(value, __u64);
+} qdisc_stats SEC(".maps");
+
+static __always_inline void count_skb(void *skbaddr, __u32 kind)
+{
+    struct sk_buff *skb = skbaddr;
+    struct net_device *dev = NULL;
+    struct net *netp = NULL;
+    struct qdisc_key key = {};
+    __u64 one = 1;
+    __u64 *val;
+
+    bpf_probe_read_kernel(&dev, sizeof(dev), &skb->dev);
+    if (!dev)
+        return;
+
+    bpf_probe_read_kernel(&netp, sizeof(netp), &dev->nd_net.net);
+    if (netp)
+        bpf_probe_read_kernel(&key.netns, sizeof(key.netns), &netp->ns.inum);
+    if (host_netns && key.netns != host_netns)
+        return;
+
+    bpf_probe_read_kernel(&key.ifindex, sizeof(key.ifindex), &dev->ifindex);
+    key.kind = kind;
+
+    val = bpf_map_lookup_elem(&qdisc_stats, &key);
+    if (val) {
+        /* per-cpu value: no atomics needed */
+        *val += 1;
+        return;
+    }
+    bpf_map_update_elem(&qdisc_stats, &key, &one, BPF_NOEXIST);
+}
+
+/* tracepoint: kfree_skb (drop reasons need Linux 5.17+)
+ *
+ * enum skb_drop_reason is renumbered between kernels, so the value is
+ * relocated against the running kernel's BTF instead of vmlinux.h.
+ */
+SEC("tracepoint/skb/kfree_skb")
+int tracepoint__skb__kfree_skb(struct trace_event_raw_kfree_skb *ctx)
+{
+    if (!bpf_core_enum_value_exists(enum skb_drop_reason, SKB_DROP_REASON_QDISC_DROP))
+        return 0;
+    if (ctx->reason != bpf_core_enum_value(enum skb_drop_reason, SKB_DROP_REASON_QDISC_DROP))
+        return 0;
+
+    count_skb(ctx->skbaddr, QDISC_EVENT_DROP);
+    return 0;
+}
+
+/* tracepoint: net_dev_xmit, a busy driver makes the qdisc requeue the skb */
+SEC("tracepoint/net/net_dev_xmit")
+int tracepoint__net__net_dev_xmit(struct trace_event_raw_net_dev_xmit *ctx)
+{
+    if (ctx->rc != NETDEV_TX_BUSY)
+        return 0;
+
+    count_skb(ctx->skbaddr, QDISC_EVENT_REQUEUE);
+    return 0;
+}
+
+char LICENSE[] SEC("license") = "GPL";
@@ -11,25 +11,32 @@
 #define AF_INET 2
 #define AF_INET6 10
 
-/* Event structure sent to userspace via perf buffer */
+/*
+ * Event sent to userspace via ring buffer or perf buffer: the common
+ * header (module MODULE_TCPMONITOR, type 1 = retransmit, 2 = sent reset,
+ * 3 = received reset) followed by the payload. New payload fields go at
+ * the end, see event.h.
+ */
 struct event {
-    __u64 timestamp;          // 8 bytes
-
-    pid_t pid;                // pid_t is 32-bit on Linux
-    int state;              // int is 32-bit in kernel
-    __u32 type;
-    __u32 netns;
+    struct flow_event_header hdr;
 
     __u16 sport;
     __u16 dport;
     __u16 family;
+    __u16 _pad;
+    int   state;              // int is 32-bit in kernel
 
     __u8  saddr[4];
     __u8  daddr[4];
     __u8  saddr_v6[16];
     __u8  daddr_v6[16];
+
+    __u32 weight;             // kernel events this one stands for
 };
 
+/* keeps struct event in the object's BTF for the Go layout test */
+const struct event *unused_event __attribute__((unused));
+
 /* tracepoint context layout used (partial) */
 struct tcp_tp_ctx {
     __u64 _pad0;
@@ -45,13 +52,139 @@ struct tcp_tp_ctx {
     __u8  daddr_v6[16];
 };
 
-/* perf event map for user-space events */
+/* Set by the loader when the kernel has BPF_MAP_TYPE_RINGBUF (5.8+).
+ * Otherwise the loader turns "events" into a perf event array and the
+ * ring buffer branches below are dead code to the verifier. */
+const volatile __u8 use_ringbuf = 0;
+
+/* Set by the loader to count events in tcp_agg instead of emitting them. */
+const volatile __u8 aggregate = 0;
+
+/*
+ * Per-flow sampling and rate limiting, set by the loader. The first event
+ * of every window of sample_ratio events of a flow is emitted, and at most
+ * one per rate_cost_ns with bursts of up to rate_burst_ns worth of events
+ * (rate_cost_ns == 0 disables the limit). Events held back are counted
+ * in the weight of the next emitted event of the flow.
+ */
+const volatile __u32 sample_ratio = 1;
+const volatile __u64 rate_cost_ns = 0;
+const volatile __u64 rate_burst_ns = 0;
+
+struct flow_bucket {
+    __u64 last_ns;
+    __u64 credit_ns;
+    __u32 pending; /* events held back since the last emitted one */
+    __u32 seen;    /* position in the current sampling window */
+};
+
+#ifndef FLOW_LIMIT_MAX_ENTRIES
+#define FLOW_LIMIT_MAX_ENTRIES 65536
+#endif
+
 struct {
-    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-    __uint(key_size, sizeof(__u32));
-    __uint(value_size, sizeof(__u32));
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, FLOW_LIMIT_MAX_ENTRIES);
+    __type(key, struct flow_key_t);
+    __type(value, struct flow_bucket);
+} flow_limits SEC(".maps");
+
+/* aggregation key; explicit padding keeps the layout stable for Go */
+struct agg_key {
+    __u32 netns;
+    __u32 pid;
+    __u64 cgroup;             // keeps processes reusing a PID apart
+    __u8  saddr[4];
+    __u8  daddr[4];
+    __u16 dport;
+    __u16 _pad;
+    __s32 state;
+    __u32 type;
+    __u32 _pad2;
+};
+
+#ifndef TCP_AGG_MAX_ENTRIES
+#define TCP_AGG_MAX_ENTRIES 65536
+#endif
+
+/* event counts drained periodically by userspace in aggregation mode */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, TCP_AGG_MAX_ENTRIES);
+    __type(key, struct agg_key);
+    __type(value, __u64);
+} tcp_agg SEC(".maps");
+
+/*
+ * Event filter, written by userspace and updatable while the programs run.
+ * An event is dropped when its type is not in types (0 keeps every type),
+ * its netns is denied or, with FILTER_NETNS_ALLOW, not allowed, neither
+ * port falls in one of the port ranges (FILTER_PORTS), or neither address
+ * is in filter_cidrs (FILTER_CIDRS).
+ */
+#define FILTER_NETNS_ALLOW (1 << 0)
+#define FILTER_PORTS       (1 << 1)
+#define FILTER_CIDRS       (1 << 2)
+
+#define FILTER_ALLOW 1
+#define FILTER_DENY  2
+
+#define FILTER_MAX_PORT_RANGES 16
+
+struct port_range {
+    __u16 low;
+    __u16 high;
+};
+
+struct filter_config {
+    __u32 flags;
+    __u32 types;
+    __u32 nr_ports;
+    struct port_range ports[FILTER_MAX_PORT_RANGES];
+};
+
+struct cidr_key {
+    __u32 prefixlen;
+    __u8  addr[4];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct filter_config);
+} filter_config SEC(".maps");
+
+/* netns inode -> FILTER_ALLOW or FILTER_DENY */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u32);
+    __type(value, __u8);
+} filter_netns SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 256);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct cidr_key);
+    __type(value, __u8);
+} filter_cidrs SEC(".maps");
+
+/* user-space events: ring buffer, or perf event array on older kernels */
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
 } events SEC(".maps");
 
+/* events lost because the ring buffer was full, read by userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} ringbuf_drops SEC(".maps");
+
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 10240);
@@ -60,6 +193,152 @@ struct {
 } connect_sk_map SEC(".maps");
 
 
+static __always_inline void emit_event(void *ctx, struct event *evt)
+{
+    if (use_ringbuf) {
+        struct event *slot = bpf_ringbuf_reserve(&events, sizeof(*slot), 0);
+        if (!slot) {
+            __u32 zero = 0;
+            __u64 *drops = bpf_map_lookup_elem(&ringbuf_drops, &zero);
+            if (drops)
+                (*drops)++;
+            return;
+        }
+        __builtin_memcpy(slot, evt, sizeof(*slot));
+        bpf_ringbuf_submit(slot, 0);
+        return;
+    }
+    bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, evt, sizeof(*evt));
+}
+
+static __always_inline bool in_port_ranges(const struct filter_config *cfg, __u16 port)
+{
+#pragma unroll
+    for (int i = 0; i < FILTER_MAX_PORT_RANGES; i++) {
+        if (i >= cfg->nr_ports)
+            break;
+        if (port >= cfg->ports[i].low && port <= cfg->ports[i].high)
+            return true;
+    }
+    return false;
+}
+
+static __always_inline bool in_filter_cidrs(const __u8 addr[4])
+{
+    struct cidr_key key = { .prefixlen = 32 };
+
+    __builtin_memcpy(key.addr, addr, 4);
+    return bpf_map_lookup_elem(&filter_cidrs, &key) != NULL;
+}
+
+static __always_inline bool keep_event(struct event *evt)
+{
+    __u32 zero = 0;
+    struct filter_config *cfg = bpf_map_lookup_elem(&filter_config, &zero);
+    __u8 *verdict;
+
+    if (!cfg)
+        return true;
+
+    if (cfg->types && (evt->hdr.type >= 32 || !(cfg->types & (1U << evt->hdr.type))))
+        return false;
+
+    verdict = bpf_map_lookup_elem(&filter_netns, &evt->hdr.netns);
+    if (verdict && *verdict == FILTER_DENY)
+        return false;
+    if ((cfg->flags & FILTER_NETNS_ALLOW) && !(verdict && *verdict == FILTER_ALLOW))
+        return false;
+
+    if ((cfg->flags & FILTER_PORTS) &&
+        !in_port_ranges(cfg, evt->dport) && !in_port_ranges(cfg, evt->sport))
+        return false;
+
+    if ((cfg->flags & FILTER_CIDRS) &&
+        !in_filter_cidrs(evt->daddr) && !in_filter_cidrs(evt->saddr))
+        return false;
+
+    return true;
+}
+
+/*
+ * take_sample decides whether evt is emitted and sets its weight. The
+ * first event of a flow is always emitted, so a lone reset is never lost;
+ * only events held back after the last emitted one are unreported when a
+ * flow goes quiet or its bucket is evicted. Buckets are shared between
+ * CPUs without locking, so concurrent events of one flow can be
+ * miscounted by a few.
+ */
+static __always_inline bool take_sample(struct flow_key_t *key, struct event *evt)
+{
+    struct flow_bucket *b, fresh = {};
+    __u64 now = evt->hdr.timestamp;
+    bool first;
+
+    if (sample_ratio <= 1 && !rate_cost_ns)
+        return true;
+
+    b = bpf_map_lookup_elem(&flow_limits, key);
+    if (!b) {
+        fresh.last_ns = now;
+        fresh.credit_ns = rate_burst_ns;
+        bpf_map_update_elem(&flow_limits, key, &fresh, BPF_NOEXIST);
+        b = bpf_map_lookup_elem(&flow_limits, key);
+        if (!b)
+            return true;
+    }
+
+    first = b->seen == 0;
+    b->seen++;
+    if (b->seen >= sample_ratio)
+        b->seen = 0;
+    if (!first) {
+        b->pending++;
+        return false;
+    }
+
+    if (rate_cost_ns) {
+        __u64 credit = b->credit_ns;
+
+        if (now > b->last_ns)
+            credit += now - b->last_ns;
+        if (credit > rate_burst_ns)
+            credit = rate_burst_ns;
+        b->last_ns = now;
+        if (credit < rate_cost_ns) {
+            b->credit_ns = credit;
+            b->pending++;
+            return false;
+        }
+        b->credit_ns = credit - rate_cost_ns;
+    }
+
+    evt->weight = b->pending + 1;
+    b->pending = 0;
+    return true;
+}
+
+static __always_inline void count_event(const struct event *evt)
+{
+    struct agg_key key = {};
+    __u64 one = 1, *count;
+
+    key.netns = evt->hdr.netns;
+    key.pid = evt->hdr.pid;
+    key.cgroup = evt->hdr.cgroup;
+    __builtin_memcpy(key.saddr, evt->saddr, 4);
+    __builtin_memcpy(key.daddr, evt->daddr, 4);
+    key.dport = evt->dport;
+    key.state = evt->state;
+    key.type = evt->hdr.type;
+
+    count = bpf_map_lookup_elem(&tcp_agg, &key);
+    if (count) {
+        (*count)++;
+        return;
+    }
+    bpf_map_update_elem(&tcp_agg, &key, &one, BPF_NOEXIST);
+}
+
 static inline int tcp_helper(struct tcp_tp_ctx *ctx, __u32 type) {
     struct event evt = {};
     struct flow_key_t key = {};
@@ -81,14 +360,13 @@ static inline int tcp_helper(struct tcp_tp_ctx *ctx, __u32 type) {
 
     __u16 sport = ctx->sport;
     __u16 dport = ctx->dport;
-    evt.type = type;
-    evt.timestamp = bpf_ktime_get_ns();
+    init_event_header(&evt.hdr, MODULE_TCPMONITOR, type, sizeof(evt));
     evt.sport = sport;
     evt.dport = dport;
     evt.family = ctx->family;
     evt.state = ctx->state;
-    evt.netns = inum;
-    evt.pid = 0;
+    evt.hdr.netns = inum;
+    evt.weight = 1;
 
     /* Build key and copy addresses according to family */
     if (evt.family == AF_INET) {
@@ -101,13 +379,26 @@ static inline int tcp_helper(struct tcp_tp_ctx *ctx, __u32 type) {
         return 0;
     }
 
-    pid_t *pid = bpf_map_lookup_elem(&flow_pid_map, &key);
-    if (pid) {
-        evt.pid = *pid;
+    if (!keep_event(&evt))
+        return 0;
+
+    /* tracepoints can run in softirq, so take the owner recorded at connect */
+    struct flow_owner *owner = bpf_map_lookup_elem(&flow_pid_map, &key);
+    if (owner) {
+        evt.hdr.pid = owner->pid;
+        evt.hdr.cgroup = owner->cgroup;
     }
 
+    if (aggregate) {
+        count_event(&evt);
+        return 0;
+    }
+
+    if (!take_sample(&key, &evt))
+        return 0;
+
     /* emit connect event to userspace */
-    bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &evt, sizeof(evt));
+    emit_event(ctx, &evt);
     return 0;
 }
 
@@ -200,8 +491,12 @@ int bpf_ret_tcp_v4_connect(struct pt_regs *ctx)
     
     bpf_printk("tcp_v4_connect(ret) saddr=%x sport=%u netns=%u\n", *(__u32 *)key.saddr, key.sport, inum);
 
-    // Record pid for this flow
-    bpf_map_update_elem(&flow_pid_map, &key, &pid, BPF_ANY);
+    // Record pid and cgroup for this flow
+    struct flow_owner owner = {
+        .pid = pid,
+        .cgroup = bpf_get_current_cgroup_id(),
+    };
+    bpf_map_update_elem(&flow_pid_map, &key, &owner, BPF_ANY);
 
     return 0;
 }
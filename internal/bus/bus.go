@@ -0,0 +1,163 @@
+// Package bus fans events published by the modules out to independent
+// subscribers such as the Prometheus counters or an event log.
+package bus
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/net-lens/flow-lens/internal/common"
+)
+
+// DefaultBuffer is the number of events a subscriber may fall behind
+// before new ones are dropped for it, or Publish waits for a blocking one.
+const DefaultBuffer = 1024
+
+// Event is an attributed event published by a module. Subscribers
+// type-switch on the concrete type.
+type Event interface {
+	// Kind names the event type, e.g. "tcp_retransmit".
+	Kind() string
+}
+
+var busDrops = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "flow_lens",
+		Subsystem: "agent",
+		Name:      "bus_drops_total",
+		Help:      "Events dropped for a non-blocking subscriber whose buffer was full",
+	},
+	[]string{"subscriber"},
+)
+
+func init() {
+	common.RegisterMetric(busDrops)
+}
+
+type subscriber struct {
+	name    string
+	events  chan Event
+	block   bool
+	dropped prometheus.Counter
+}
+
+// Bus delivers every published event to every subscriber. Each subscriber
+// has its own buffer and goroutine. A slow subscriber only loses its own
+// events and never blocks the publisher or the others, unless it was added
+// with SubscribeBlocking.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+	wg   sync.WaitGroup
+}
+
+func New() *Bus {
+	return &Bus{subs: map[*subscriber]struct{}{}}
+}
+
+// Subscribe calls handle for every event published from now on, from a
+// goroutine of its own, with up to buffer events queued (DefaultBuffer if
+// buffer <= 0). The returned function unsubscribes; events already queued
+// are still handled.
+func (b *Bus) Subscribe(name string, buffer int, handle func(Event)) (unsubscribe func()) {
+	return b.subscribe(name, buffer, false, handle)
+}
+
+// SubscribeBlocking is Subscribe for subscribers that must see every
+// event, such as the Prometheus counters: once its buffer is full, Publish
+// waits for it instead of dropping, which slows the publisher down like
+// any other handler under EVENT_QUEUE_POLICY=block.
+func (b *Bus) SubscribeBlocking(name string, buffer int, handle func(Event)) (unsubscribe func()) {
+	return b.subscribe(name, buffer, true, handle)
+}
+
+func (b *Bus) subscribe(name string, buffer int, block bool, handle func(Event)) func() {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	sub := &subscriber{
+		name:    name,
+		events:  make(chan Event, buffer),
+		block:   block,
+		dropped: busDrops.WithLabelValues(name),
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for evt := range sub.events {
+			handle(evt)
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { b.remove(sub) }) }
+}
+
+func (b *Bus) remove(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	// Publish holds the read lock while sending, so nothing sends on the
+	// channel once it is closed here.
+	close(sub.events)
+}
+
+// Publish hands evt to every subscriber. It only waits for subscribers
+// added with SubscribeBlocking.
+func (b *Bus) Publish(evt Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if sub.block {
+			// The subscriber goroutine drains until the channel is
+			// closed, which needs the write lock, so this cannot hang.
+			sub.events <- evt
+			continue
+		}
+		select {
+		case sub.events <- evt:
+		default:
+			sub.dropped.Inc()
+		}
+	}
+}
+
+// Close unsubscribes everyone and waits until queued events are handled.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// LogSubscriber writes every event to w as a JSON line
+// {"time": ..., "kind": ..., "event": {...}}.
+func LogSubscriber(w io.Writer) func(Event) {
+	enc := json.NewEncoder(w)
+	return func(evt Event) {
+		line := struct {
+			Time  time.Time `json:"time"`
+			Kind  string    `json:"kind"`
+			Event Event     `json:"event"`
+		}{time.Now().UTC(), evt.Kind(), evt}
+		if err := enc.Encode(line); err != nil {
+			log.Printf("[bus] log %s event: %v", evt.Kind(), err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testEvent struct {
+	N int `json:"n"`
+}
+
+func (testEvent) Kind() string { return "test" }
+
+func TestPublishReachesEverySubscriber(t *testing.T) {
+	b := New()
+
+	var mu sync.Mutex
+	got := map[string][]int{}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		b.Subscribe(name, 0, func(evt Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			got[name] = append(got[name], evt.(testEvent).N)
+		})
+	}
+
+	for i := 1; i <= 3; i++ {
+		b.Publish(testEvent{N: i})
+	}
+	b.Close()
+
+	for _, name := range []string{"a", "b"} {
+		if len(got[name]) != 3 || got[name][0] != 1 || got[name][2] != 3 {
+			t.Fatalf("subscriber %s got %v, want [1 2 3]", name, got[name])
+		}
+	}
+}
+
+func TestSlowSubscriberDropsOnlyItsOwnEvents(t *testing.T) {
+	b := New()
+
+	release := make(chan struct{})
+	b.Subscribe("slow", 1, func(Event) { <-release })
+
+	var fast []int
+	b.Subscribe("fast", 16, func(evt Event) { fast = append(fast, evt.(testEvent).N) })
+
+	for i := 0; i < 10; i++ {
+		b.Publish(testEvent{N: i})
+	}
+	close(release)
+	b.Close()
+
+	if len(fast) != 10 {
+		t.Fatalf("fast subscriber got %d events, want 10", len(fast))
+	}
+	// The slow subscriber holds one event and buffers one more at most.
+	if drops := testutil.ToFloat64(busDrops.WithLabelValues("slow")); drops < 8 {
+		t.Fatalf("slow subscriber drops = %v, want at least 8", drops)
+	}
+}
+
+func TestBlockingSubscriberMissesNothing(t *testing.T) {
+	b := New()
+
+	release := make(chan struct{})
+	var got []int
+	b.SubscribeBlocking("counters", 1, func(evt Event) {
+		<-release
+		got = append(got, evt.(testEvent).N)
+	})
+	b.Subscribe("lossy", 1, func(Event) { <-release })
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 10; i++ {
+			b.Publish(testEvent{N: i})
+		}
+	}()
+
+	select {
+	case <-published:
+		t.Fatalf("Publish did not wait for the blocking subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-published
+	b.Close()
+
+	if len(got) != 10 || got[9] != 9 {
+		t.Fatalf("blocking subscriber got %v, want 0..9", got)
+	}
+	if drops := testutil.ToFloat64(busDrops.WithLabelValues("counters")); drops != 0 {
+		t.Fatalf("blocking subscriber drops = %v, want 0", drops)
+	}
+	if drops := testutil.ToFloat64(busDrops.WithLabelValues("lossy")); drops == 0 {
+		t.Fatalf("non-blocking subscriber dropped nothing while stalled")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New()
+	defer b.Close()
+
+	done := make(chan struct{})
+	unsubscribe := b.Subscribe("once", 4, func(Event) {})
+
+	b.Publish(testEvent{})
+	unsubscribe()
+	unsubscribe()
+	b.Publish(testEvent{})
+
+	b.Subscribe("sync", 1, func(Event) { close(done) })
+	b.Publish(testEvent{})
+	<-done
+
+	b.mu.RLock()
+	subs := len(b.subs)
+	b.mu.RUnlock()
+	if subs != 1 {
+		t.Fatalf("%d subscribers left, want 1", subs)
+	}
+}
+
+func TestLogSubscriber(t *testing.T) {
+	var buf bytes.Buffer
+	LogSubscriber(&buf)(testEvent{N: 7})
+
+	var line struct {
+		Kind  string    `json:"kind"`
+		Event testEvent `json:"event"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if line.Kind != "test" || line.Event.N != 7 {
+		t.Fatalf("unexpected line %q", buf.String())
+	}
+}
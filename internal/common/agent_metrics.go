@@ -0,0 +1,79 @@
+package common
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Agent self-observability, labeled by the event map ("source") a reader
+// consumes.
+var (
+	AgentLostSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "lost_samples_total",
+			Help:      "Samples the kernel overwrote in a perf buffer before they were read",
+		},
+		[]string{"source"},
+	)
+
+	AgentRingbufDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "ringbuf_drops_total",
+			Help:      "Events dropped in BPF because the ring buffer was full",
+		},
+		[]string{"source"},
+	)
+
+	AgentQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "queue_depth",
+			Help:      "Events read from the kernel and waiting for a handler",
+		},
+		[]string{"source"},
+	)
+
+	AgentQueueDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "queue_drops_total",
+			Help:      "Events discarded by the drop-oldest queue policy",
+		},
+		[]string{"source"},
+	)
+
+	AgentHandlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent handling one event",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+		[]string{"source"},
+	)
+
+	AgentUnknownEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "unknown_events_total",
+			Help:      "Events skipped because the agent has no decoder for their type",
+		},
+		[]string{"source"},
+	)
+)
+
+func init() {
+	RegisterMetric(AgentLostSamples)
+	RegisterMetric(AgentRingbufDrops)
+	RegisterMetric(AgentQueueDepth)
+	RegisterMetric(AgentQueueDrops)
+	RegisterMetric(AgentHandlerDuration)
+	RegisterMetric(AgentUnknownEvents)
+}
@@ -1,11 +1,15 @@
 package common
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 //
@@ -86,6 +90,207 @@ func AttachTracepoint(category, name string, prog *ebpf.Program) (link.Link, err
 	return ln, nil
 }
 
+//
+// -----------------------------------------------------------------------
+//  BTF TRACING (tp_btf / fentry / fexit)
+// -----------------------------------------------------------------------
+//
+
+// AttachTracing attaches a BTF-enabled tracing program. The attach point
+// comes from the program's ELF section, e.g. SEC("tp_btf/sched_process_exit").
+func AttachTracing(prog *ebpf.Program) (link.Link, error) {
+	if prog == nil {
+		return nil, fmt.Errorf("tracing attach: nil program")
+	}
+	ln, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return nil, fmt.Errorf("attach tracing %s: %w", prog, err)
+	}
+	return ln, nil
+}
+
+//
+// -----------------------------------------------------------------------
+//  TC (TCX / CLSACT)
+// -----------------------------------------------------------------------
+//
+
+// TCDirection selects the traffic-control hook a program is attached to.
+type TCDirection int
+
+const (
+	TCIngress TCDirection = iota
+	TCEgress
+)
+
+func (d TCDirection) String() string {
+	if d == TCEgress {
+		return "egress"
+	}
+	return "ingress"
+}
+
+// AttachTC attaches a SCHED_CLS program to an interface by name.
+// It prefers a tcx link (Linux 6.6+) and falls back to a clsact qdisc
+// filter when the kernel does not support tcx.
+// Example: closer, _ := AttachTC("veth1234", objs.TcProg, TCIngress)
+func AttachTC(iface string, prog *ebpf.Program, dir TCDirection) (io.Closer, error) {
+	ifIndex, err := ResolveInterfaceIndex(iface)
+	if err != nil {
+		return nil, err
+	}
+	return AttachTCIndex(ifIndex, prog, dir)
+}
+
+// AttachTCIndex is AttachTC for callers that already know the ifindex.
+func AttachTCIndex(ifIndex int, prog *ebpf.Program, dir TCDirection) (io.Closer, error) {
+	ln, err := AttachTCX(ifIndex, prog, dir)
+	if err == nil {
+		return ln, nil
+	}
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		return nil, err
+	}
+	return AttachClsact(ifIndex, prog, dir)
+}
+
+// AttachTCX attaches a program through a tcx link. The link is owned by
+// the returned value and detached on Close.
+func AttachTCX(ifIndex int, prog *ebpf.Program, dir TCDirection) (link.Link, error) {
+	if prog == nil {
+		return nil, fmt.Errorf("tcx attach: nil program")
+	}
+
+	attachType := ebpf.AttachTCXIngress
+	if dir == TCEgress {
+		attachType = ebpf.AttachTCXEgress
+	}
+
+	ln, err := link.AttachTCX(link.TCXOptions{
+		Interface: ifIndex,
+		Program:   prog,
+		Attach:    attachType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("attach tcx %s ifindex %d: %w", dir, ifIndex, err)
+	}
+	return ln, nil
+}
+
+// The clsact filter gets its own priority and handle so it never replaces
+// a filter another agent installed; CNI plugins typically use 1/1.
+const (
+	clsactPriority = 0x4f4c
+	clsactHandle   = 0x4f4c
+	clsactName     = "flow-lens"
+)
+
+var errClsactSlotTaken = errors.New("clsact filter priority already in use")
+
+// ClsactFilter is a direct-action BPF filter installed on a clsact qdisc.
+type ClsactFilter struct {
+	filter *netlink.BpfFilter
+}
+
+// Close removes the filter. The clsact qdisc itself is left in place since
+// other agents (CNI plugins, Cilium, ...) may share it.
+func (f *ClsactFilter) Close() error {
+	if f == nil || f.filter == nil {
+		return nil
+	}
+	err := netlink.FilterDel(f.filter)
+	f.filter = nil
+	if err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ENODEV) {
+		return fmt.Errorf("delete clsact filter: %w", err)
+	}
+	return nil
+}
+
+// AttachClsact installs prog as a direct-action filter on the clsact qdisc
+// of the interface, creating the qdisc if needed. A filter of another
+// agent at the flow-lens priority is reported as a conflict, not replaced.
+func AttachClsact(ifIndex int, prog *ebpf.Program, dir TCDirection) (*ClsactFilter, error) {
+	if prog == nil {
+		return nil, fmt.Errorf("clsact attach: nil program")
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: ifIndex,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := netlink.QdiscAdd(qdisc); err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, fmt.Errorf("add clsact qdisc ifindex %d: %w", ifIndex, err)
+	}
+
+	parent := uint32(netlink.HANDLE_MIN_INGRESS)
+	if dir == TCEgress {
+		parent = netlink.HANDLE_MIN_EGRESS
+	}
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: ifIndex,
+			Parent:    parent,
+			Handle:    clsactHandle,
+			Protocol:  unix.ETH_P_ALL,
+			Priority:  clsactPriority,
+		},
+		Fd:           prog.FD(),
+		Name:         clsactName,
+		DirectAction: true,
+	}
+
+	existing, err := clsactFilterAt(ifIndex, parent)
+	if err != nil {
+		return nil, fmt.Errorf("attach clsact %s ifindex %d: %w", dir, ifIndex, err)
+	}
+	switch {
+	case existing == nil:
+		err = netlink.FilterAdd(filter)
+		if errors.Is(err, unix.EEXIST) {
+			err = errClsactSlotTaken
+		}
+	case existing.Name == clsactName:
+		// Left behind by an earlier run of the agent.
+		err = netlink.FilterReplace(filter)
+	default:
+		err = fmt.Errorf("%w by %q", errClsactSlotTaken, existing.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("attach clsact %s ifindex %d: %w", dir, ifIndex, err)
+	}
+
+	return &ClsactFilter{filter: filter}, nil
+}
+
+// clsactFilterAt returns the BPF filter at the flow-lens priority and
+// handle under parent, or nil.
+func clsactFilterAt(ifIndex int, parent uint32) (*netlink.BpfFilter, error) {
+	lnk, err := netlink.LinkByIndex(ifIndex)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := netlink.FilterList(lnk, parent)
+	if err != nil {
+		return nil, fmt.Errorf("list filters: %w", err)
+	}
+	for _, f := range filters {
+		attrs := f.Attrs()
+		if attrs.Priority != clsactPriority || attrs.Handle != clsactHandle {
+			continue
+		}
+		if bpf, ok := f.(*netlink.BpfFilter); ok {
+			return bpf, nil
+		}
+		return &netlink.BpfFilter{FilterAttrs: *attrs, Name: f.Type()}, nil
+	}
+	return nil, nil
+}
+
 //
 // -----------------------------------------------------------------------
 //  INTERFACE UTILS
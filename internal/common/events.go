@@ -4,101 +4,358 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/perf"
 	"github.com/cilium/ebpf/ringbuf"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	DefaultEventWorkers   = 4
+	DefaultEventQueueSize = 1000
+)
+
+// ErrSourceClosed is returned by a RecordSource once it has been closed.
+var ErrSourceClosed = errors.New("record source closed")
+
+// QueuePolicy decides what the reader does when all workers are busy and
+// the queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock stops reading until a worker frees a slot; the kernel
+	// buffer absorbs the backlog and loses samples once it is full too.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest discards the oldest queued event to make room, so
+	// the freshest events are kept.
+	QueueDropOldest
+)
+
+// ParseQueuePolicy parses "block" or "drop-oldest".
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	switch s {
+	case "", "block":
+		return QueueBlock, nil
+	case "drop-oldest":
+		return QueueDropOldest, nil
+	default:
+		return QueueBlock, fmt.Errorf("unknown queue policy %q", s)
+	}
+}
+
+// ReaderOptions tune an EventReader.
+type ReaderOptions struct {
+	// Workers defaults to DefaultEventWorkers. Use 1 when events must be
+	// handled in order.
+	Workers int
+	// QueueSize bounds the samples waiting for a worker. Defaults to
+	// DefaultEventQueueSize.
+	QueueSize int
+	Policy    QueuePolicy
+}
+
+// Record is one sample read from a BPF perf or ring buffer.
+type Record struct {
+	Sample []byte
+	// LostSamples is set by perf buffers when the kernel overwrote
+	// samples before they were read.
+	LostSamples uint64
+}
+
+// RecordSource is a blocking stream of records. Close must unblock a
+// pending Read, which then returns ErrSourceClosed.
+type RecordSource interface {
+	Read() (Record, error)
+	Close() error
+}
+
 //
 // -----------------------------------------------------------------------
-//  RING BUFFER POLLING
+//  EVENT READER
 // -----------------------------------------------------------------------
 //
 
-// PollRingbuf reads events from a ring buffer and passes them to handler.
-// Handles shutdown via context, and prints lost events if present.
-func PollRingbuf(ctx context.Context, rb *ringbuf.Reader, handler func([]byte)) error {
-	if rb == nil {
-		return fmt.Errorf("nil ringbuf passed to PollRingbuf")
+// EventReader reads records from Source and hands their samples to Handler
+// on a bounded pool of workers. Handler errors are logged and do not stop
+// the reader; errors from Source end Run. Name is the "source" label of
+// the flow_lens_agent_* metrics.
+type EventReader struct {
+	Name    string
+	Source  RecordSource
+	Handler func([]byte) error
+	ReaderOptions
+}
+
+// Run reads until ctx is done or the source fails. On shutdown the source
+// is closed, samples already queued are handled, and Run returns once all
+// workers are done.
+func (r *EventReader) Run(ctx context.Context) error {
+	if r.Source == nil || r.Handler == nil {
+		return fmt.Errorf("%s: event reader needs a source and a handler", r.Name)
 	}
 
+	workers := r.Workers
+	if workers <= 0 {
+		workers = DefaultEventWorkers
+	}
+	queueSize := r.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultEventQueueSize
+	}
+
+	queue := make(chan []byte, queueSize)
+	depth := AgentQueueDepth.WithLabelValues(r.Name)
+	latency := AgentHandlerDuration.WithLabelValues(r.Name)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sample := range queue {
+				depth.Set(float64(len(queue)))
+				start := time.Now()
+				if err := r.Handler(sample); err != nil {
+					log.Printf("[%s] handle event: %v", r.Name, err)
+				}
+				latency.Observe(time.Since(start).Seconds())
+			}
+		}()
+	}
+
+	// Closing the source is what unblocks Read on shutdown.
+	stop := context.AfterFunc(ctx, func() { r.Source.Close() })
+	defer stop()
+
+	err := r.read(ctx, queue)
+
+	// Only the reading goroutine sends, so closing here cannot race.
+	close(queue)
+	wg.Wait()
+	depth.Set(0)
+	r.Source.Close()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (r *EventReader) read(ctx context.Context, queue chan []byte) error {
+	lost := AgentLostSamples.WithLabelValues(r.Name)
+	dropped := AgentQueueDrops.WithLabelValues(r.Name)
+	depth := AgentQueueDepth.WithLabelValues(r.Name)
+
 	for {
+		record, err := r.Source.Read()
+		if err != nil {
+			if errors.Is(err, ErrSourceClosed) && ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%s: read event: %w", r.Name, err)
+		}
+		if record.LostSamples > 0 {
+			lost.Add(float64(record.LostSamples))
+		}
+		if len(record.Sample) == 0 {
+			continue
+		}
+
+		if r.Policy == QueueDropOldest {
+			enqueueDropOldest(queue, record.Sample, dropped)
+			depth.Set(float64(len(queue)))
+			continue
+		}
+
 		select {
+		case queue <- record.Sample:
+			depth.Set(float64(len(queue)))
 		case <-ctx.Done():
 			return nil
+		}
+	}
+}
 
+// enqueueDropOldest never blocks: when the queue is full the oldest
+// sample is discarded. Workers may drain the queue concurrently, so
+// both steps are non-blocking and retried.
+func enqueueDropOldest(queue chan []byte, sample []byte, dropped prometheus.Counter) {
+	for {
+		select {
+		case queue <- sample:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+			dropped.Inc()
 		default:
-			record, err := rb.Read()
-			if err != nil {
-				// Normal shutdown
-				if errors.Is(err, ringbuf.ErrClosed) {
-					return nil
-				}
-				return err
-			}
-
-			if len(record.RawSample) == 0 {
-				continue
-			}
-			fmt.Printf("Ringbuf read: %+v\n", record.RawSample)
-			handler(record.RawSample)
 		}
 	}
 }
 
+// NewEventSource reads m through a ring buffer or perf reader, matching
+// the type the map was created with.
+func NewEventSource(m *ebpf.Map) (RecordSource, error) {
+	if m == nil {
+		return nil, fmt.Errorf("nil event map")
+	}
+	switch m.Type() {
+	case ebpf.RingBuf:
+		return NewRingbufSource(m)
+	case ebpf.PerfEventArray:
+		return NewPerfSource(m, os.Getpagesize())
+	default:
+		return nil, fmt.Errorf("unsupported event map type %s", m.Type())
+	}
+}
+
+// PollEvents handles the samples of the named ring buffer or perf event
+// array until ctx is done.
+func PollEvents(ctx context.Context, coll *ebpf.Collection, eventName string, opts ReaderOptions, handler func([]byte) error) error {
+	src, err := NewEventSource(coll.Maps[eventName])
+	if err != nil {
+		return err
+	}
+	r := &EventReader{Name: eventName, Source: src, Handler: handler, ReaderOptions: opts}
+	return r.Run(ctx)
+}
+
 //
 // -----------------------------------------------------------------------
-//  PERF BUFFER POLLING
+//  PERF BUFFER
 // -----------------------------------------------------------------------
 //
 
-// PollPerf continuously reads perf event records and calls the handler.
-// Gracefully handles shutdown via context and lost samples.
-func PollPerf(coll *ebpf.Collection, eventName string, handler func([]byte)) error {
-
-	events, err := perf.NewReader(coll.Maps[eventName], os.Getpagesize())
+type perfSource struct {
+	rd *perf.Reader
+}
 
+// NewPerfSource reads a BPF_MAP_TYPE_PERF_EVENT_ARRAY with perCPUBuffer
+// bytes of buffer per CPU.
+func NewPerfSource(m *ebpf.Map, perCPUBuffer int) (RecordSource, error) {
+	if m == nil {
+		return nil, fmt.Errorf("nil perf event array")
+	}
+	rd, err := perf.NewReader(m, perCPUBuffer)
 	if err != nil {
-		return fmt.Errorf("create perf reader: %w", err)
+		return nil, fmt.Errorf("create perf reader: %w", err)
 	}
-	defer events.Close()
-
-	// Channel for signal handling
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	eventsCh := make(chan []byte, 1000)
+	return &perfSource{rd: rd}, nil
+}
 
-	go func() {
-		for {
-			record, err := events.Read()
-			if err != nil {
-				if perf.IsUnknownEvent(err) {
-					continue
-				}
-				panic(err)
+func (s *perfSource) Read() (Record, error) {
+	for {
+		record, err := s.rd.Read()
+		if err != nil {
+			if perf.IsUnknownEvent(err) {
+				continue
 			}
-			eventsCh <- record.RawSample
+			if errors.Is(err, perf.ErrClosed) {
+				return Record{}, ErrSourceClosed
+			}
+			return Record{}, err
 		}
-	}()
+		return Record{Sample: record.RawSample, LostSamples: record.LostSamples}, nil
+	}
+}
 
-	go func() {
-		for raw := range eventsCh {
-			handler(raw)
-		}
-	}()
-	<-sig
-	close(eventsCh)
-	events.Close()
+func (s *perfSource) Close() error {
+	return s.rd.Close()
+}
 
-	return nil
+// PollPerf handles the samples of the named perf event array until ctx is
+// done.
+func PollPerf(ctx context.Context, coll *ebpf.Collection, eventName string, opts ReaderOptions, handler func([]byte) error) error {
+	src, err := NewPerfSource(coll.Maps[eventName], os.Getpagesize())
+	if err != nil {
+		return err
+	}
+	r := &EventReader{Name: eventName, Source: src, Handler: handler, ReaderOptions: opts}
+	return r.Run(ctx)
 }
 
 //
 // -----------------------------------------------------------------------
-//  GENERIC EVENT LOOP WRAPPER (Optional)
+//  RING BUFFER
 // -----------------------------------------------------------------------
 //
+
+type ringbufSource struct {
+	rd *ringbuf.Reader
+}
+
+// NewRingbufSource reads a BPF_MAP_TYPE_RINGBUF.
+func NewRingbufSource(m *ebpf.Map) (RecordSource, error) {
+	if m == nil {
+		return nil, fmt.Errorf("nil ring buffer")
+	}
+	rd, err := ringbuf.NewReader(m)
+	if err != nil {
+		return nil, fmt.Errorf("create ringbuf reader: %w", err)
+	}
+	return &ringbufSource{rd: rd}, nil
+}
+
+func (s *ringbufSource) Read() (Record, error) {
+	record, err := s.rd.Read()
+	if err != nil {
+		if errors.Is(err, ringbuf.ErrClosed) {
+			return Record{}, ErrSourceClosed
+		}
+		return Record{}, err
+	}
+	return Record{Sample: record.RawSample}, nil
+}
+
+func (s *ringbufSource) Close() error {
+	return s.rd.Close()
+}
+
+// PollRingbuf handles the samples of the named ring buffer until ctx is
+// done.
+func PollRingbuf(ctx context.Context, coll *ebpf.Collection, eventName string, opts ReaderOptions, handler func([]byte) error) error {
+	src, err := NewRingbufSource(coll.Maps[eventName])
+	if err != nil {
+		return err
+	}
+	r := &EventReader{Name: eventName, Source: src, Handler: handler, ReaderOptions: opts}
+	return r.Run(ctx)
+}
+
+// PollDropCounter adds the increase of a one-entry per-CPU u64 drop
+// counter to flow_lens_agent_ringbuf_drops_total every interval until ctx
+// is done.
+func PollDropCounter(ctx context.Context, m *ebpf.Map, source string, interval time.Duration) {
+	drops := AgentRingbufDrops.WithLabelValues(source)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var perCPU []uint64
+		if err := m.Lookup(uint32(0), &perCPU); err != nil {
+			log.Printf("[%s] read ring buffer drops: %v", source, err)
+			continue
+		}
+		var total uint64
+		for _, v := range perCPU {
+			total += v
+		}
+		if total >= last {
+			drops.Add(float64(total - last))
+		}
+		last = total
+	}
+}
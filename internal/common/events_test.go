@@ -0,0 +1,204 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSource serves scripted records, then blocks until closed.
+type fakeSource struct {
+	records chan Record
+	err     error
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newFakeSource(samples ...string) *fakeSource {
+	s := &fakeSource{records: make(chan Record, len(samples)), closed: make(chan struct{})}
+	for _, sample := range samples {
+		s.records <- Record{Sample: []byte(sample)}
+	}
+	return s
+}
+
+func (s *fakeSource) Read() (Record, error) {
+	select {
+	case r := <-s.records:
+		return r, nil
+	default:
+	}
+	if s.err != nil {
+		return Record{}, s.err
+	}
+	<-s.closed
+	return Record{}, ErrSourceClosed
+}
+
+func (s *fakeSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestEventReaderHandlesAndDrains(t *testing.T) {
+	src := newFakeSource("a", "", "b", "bad", "c")
+
+	var mu sync.Mutex
+	var got []string
+	handled := make(chan struct{}, 8)
+
+	r := &EventReader{
+		Name:   "test",
+		Source: src,
+		Handler: func(sample []byte) error {
+			defer func() { handled <- struct{}{} }()
+			if string(sample) == "bad" {
+				return errors.New("decode failed")
+			}
+			mu.Lock()
+			got = append(got, string(sample))
+			mu.Unlock()
+			return nil
+		},
+		ReaderOptions: ReaderOptions{Workers: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	for i := 0; i < 4; i++ { // the empty sample is skipped
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for sample %d", i)
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not return after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"a", "b", "c"}; len(got) != len(want) || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("handled %v, want %v in order", got, want)
+	}
+}
+
+func TestEventReaderPropagatesSourceErrors(t *testing.T) {
+	src := newFakeSource("a")
+	src.err = errors.New("ring buffer gone")
+
+	var handled int
+	r := &EventReader{
+		Name:          "test",
+		Source:        src,
+		Handler:       func([]byte) error { handled++; return nil },
+		ReaderOptions: ReaderOptions{Workers: 1},
+	}
+
+	err := r.Run(context.Background())
+	if err == nil || !errors.Is(err, src.err) {
+		t.Fatalf("expected source error, got %v", err)
+	}
+	if handled != 1 {
+		t.Fatalf("expected queued sample to be handled before returning, got %d", handled)
+	}
+	select {
+	case <-src.closed:
+	default:
+		t.Fatalf("expected source to be closed")
+	}
+}
+
+func TestEventReaderUnblocksFullQueueOnCancel(t *testing.T) {
+	src := newFakeSource("a", "b", "c")
+	release := make(chan struct{})
+
+	r := &EventReader{
+		Name:          "test",
+		Source:        src,
+		Handler:       func([]byte) error { <-release; return nil },
+		ReaderOptions: ReaderOptions{Workers: 1, QueueSize: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	time.Sleep(20 * time.Millisecond) // let the reader block on the full queue
+	cancel()
+	close(release)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not return after cancel")
+	}
+}
+
+func TestEnqueueDropOldest(t *testing.T) {
+	dropped := AgentQueueDrops.WithLabelValues("test-drop")
+	queue := make(chan []byte, 2)
+
+	for _, sample := range []string{"a", "b", "c"} {
+		enqueueDropOldest(queue, []byte(sample), dropped)
+	}
+
+	if got := testutil.ToFloat64(dropped); got != 1 {
+		t.Fatalf("expected 1 dropped sample, got %v", got)
+	}
+	if first, second := string(<-queue), string(<-queue); first != "b" || second != "c" {
+		t.Fatalf("expected the newest samples b, c; got %s, %s", first, second)
+	}
+}
+
+func TestEventReaderCountsLostSamples(t *testing.T) {
+	src := newFakeSource()
+	src.records = make(chan Record, 1)
+	src.records <- Record{LostSamples: 7}
+	src.err = ErrSourceClosed
+
+	r := &EventReader{Name: "test-lost", Source: src, Handler: func([]byte) error { return nil }}
+	if err := r.Run(context.Background()); !errors.Is(err, ErrSourceClosed) {
+		t.Fatalf("expected the closed source to end Run, got %v", err)
+	}
+	if got := testutil.ToFloat64(AgentLostSamples.WithLabelValues("test-lost")); got != 7 {
+		t.Fatalf("expected 7 lost samples, got %v", got)
+	}
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    QueuePolicy
+		wantErr bool
+	}{
+		{"", QueueBlock, false},
+		{"block", QueueBlock, false},
+		{"drop-oldest", QueueDropOldest, false},
+		{"drop-newest", QueueBlock, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseQueuePolicy(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Fatalf("ParseQueuePolicy(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -1,18 +1,35 @@
 package common
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
 )
 
 // LoadObjects is a simple wrapper to unify object loading.
 // For bpf2go, you will usually call LoadXXXObjects directly.
 func LoadObjects(objFileName string) (*ebpf.Collection, error) {
+	spec, err := LoadSpec(objFileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewCollection(spec)
+}
 
+// LoadSpec reads an object file for callers that adjust the spec before
+// loading it with NewCollection.
+func LoadSpec(objFileName string) (*ebpf.CollectionSpec, error) {
 	spec, err := ebpf.LoadCollectionSpec(objFileName)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("load %s: %w", objFileName, err)
 	}
+	return spec, nil
+}
 
+// NewCollection loads spec into the kernel with the verifier log enabled.
+func NewCollection(spec *ebpf.CollectionSpec) (*ebpf.Collection, error) {
 	return ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{
 		Programs: ebpf.ProgramOptions{
 			LogLevel: 1,
@@ -20,3 +37,39 @@ func LoadObjects(objFileName string) (*ebpf.Collection, error) {
 		},
 	})
 }
+
+// ConfigureRingbuf picks the transport of a ring buffer map that can fall
+// back to a perf event array. On kernels with ring buffers the const
+// volatile flag is set so the programs emit through it; otherwise the map
+// becomes a perf event array and the flag stays 0. Objects without the
+// flag only support perf and are left untouched. It reports whether the
+// ring buffer is used.
+func ConfigureRingbuf(spec *ebpf.CollectionSpec, mapName, flag string) (bool, error) {
+	m, ok := spec.Maps[mapName]
+	if !ok {
+		return false, fmt.Errorf("map %s not found", mapName)
+	}
+	if m.Type != ebpf.RingBuf {
+		return false, nil
+	}
+
+	if err := features.HaveMapType(ebpf.RingBuf); err == nil {
+		err := spec.RewriteConstants(map[string]interface{}{flag: uint8(1)})
+		if err == nil {
+			return true, nil
+		}
+		var missing *ebpf.MissingConstantsError
+		if !errors.As(err, &missing) {
+			return false, err
+		}
+	} else if !errors.Is(err, ebpf.ErrNotSupported) {
+		return false, fmt.Errorf("probe ring buffer support: %w", err)
+	}
+
+	// MaxEntries 0 sizes the perf event array to the possible CPUs.
+	m.Type = ebpf.PerfEventArray
+	m.KeySize = 4
+	m.ValueSize = 4
+	m.MaxEntries = 0
+	return false, nil
+}
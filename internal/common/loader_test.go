@@ -0,0 +1,25 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestConfigureRingbufLeavesPerfObjects(t *testing.T) {
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"events": {Name: "events", Type: ebpf.PerfEventArray, KeySize: 4, ValueSize: 4},
+	}}
+
+	ringbuf, err := ConfigureRingbuf(spec, "events", "use_ringbuf")
+	if err != nil || ringbuf {
+		t.Fatalf("expected perf object to be left alone, got ringbuf=%v err=%v", ringbuf, err)
+	}
+	if spec.Maps["events"].Type != ebpf.PerfEventArray {
+		t.Fatalf("map type changed to %s", spec.Maps["events"].Type)
+	}
+
+	if _, err := ConfigureRingbuf(spec, "missing", "use_ringbuf"); err == nil {
+		t.Fatalf("expected an error for a missing map")
+	}
+}
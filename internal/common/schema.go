@@ -0,0 +1,148 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// EventSchemaVersion is the newest event header version this agent knows.
+// See bpf/include/event.h for the compatibility rules.
+const EventSchemaVersion = 1
+
+// EventHeaderSize is the size of a version 1 event header.
+const EventHeaderSize = 32
+
+// EventModule identifies the module that sent an event.
+type EventModule uint8
+
+const (
+	ModuleTCPMonitor  EventModule = 1
+	ModuleProcTracker EventModule = 2
+)
+
+func (m EventModule) String() string {
+	switch m {
+	case ModuleTCPMonitor:
+		return "tcpmonitor"
+	case ModuleProcTracker:
+		return "proctracker"
+	default:
+		return fmt.Sprintf("module-%d", uint8(m))
+	}
+}
+
+// ErrUnknownEvent is returned for events no decoder is registered for.
+var ErrUnknownEvent = errors.New("unknown event type")
+
+// EventHeader mirrors struct flow_event_header in bpf/include/event.h.
+type EventHeader struct {
+	Version uint8
+	// HdrLen is where the payload starts; newer headers are longer.
+	HdrLen uint8
+	Module EventModule
+	Type   uint16
+	// Len is the size of header and payload.
+	Len       uint16
+	Timestamp uint64
+	// Cgroup is the cgroup id of PID, 0 when unknown.
+	Cgroup uint64
+	Netns  uint32
+	PID    uint32
+}
+
+// UnmarshalBinary decodes the header at the start of an event sample.
+func (h *EventHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < EventHeaderSize {
+		return fmt.Errorf("short event header: %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	h.Version = data[0]
+	h.HdrLen = data[1]
+	h.Module = EventModule(data[2])
+	h.Type = le.Uint16(data[4:])
+	h.Len = le.Uint16(data[6:])
+	h.Timestamp = le.Uint64(data[8:])
+	h.Cgroup = le.Uint64(data[16:])
+	h.Netns = le.Uint32(data[24:])
+	h.PID = le.Uint32(data[28:])
+
+	if h.Version == 0 || int(h.HdrLen) < EventHeaderSize {
+		return fmt.Errorf("invalid event header: version %d, header length %d", h.Version, h.HdrLen)
+	}
+	if int(h.Len) < int(h.HdrLen) || int(h.Len) > len(data) {
+		return fmt.Errorf("invalid event length %d for a %d byte sample", h.Len, len(data))
+	}
+	return nil
+}
+
+// Payload returns the bytes after the header, without the padding perf
+// and ring buffers may add to a sample.
+func (h *EventHeader) Payload(data []byte) []byte {
+	return data[h.HdrLen:h.Len]
+}
+
+// PayloadDecoder decodes the payload of one event type into out. It must
+// accept payloads longer than the fields it knows, which newer objects
+// append.
+type PayloadDecoder[T any] func(hdr EventHeader, payload []byte, out *T) error
+
+type eventKind struct {
+	module EventModule
+	typ    uint16
+}
+
+// EventRegistry maps (module, type) to the decoder of a module's event
+// type T.
+type EventRegistry[T any] struct {
+	mu       sync.RWMutex
+	decoders map[eventKind]PayloadDecoder[T]
+}
+
+// NewEventRegistry returns an empty registry.
+func NewEventRegistry[T any]() *EventRegistry[T] {
+	return &EventRegistry[T]{decoders: map[eventKind]PayloadDecoder[T]{}}
+}
+
+// Register sets the decoder for events of module and type.
+func (r *EventRegistry[T]) Register(module EventModule, typ uint16, dec PayloadDecoder[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decoders[eventKind{module, typ}] = dec
+}
+
+// Decode decodes sample into out. Events without a registered decoder
+// return an error wrapping ErrUnknownEvent.
+func (r *EventRegistry[T]) Decode(sample []byte, out *T) (EventHeader, error) {
+	var hdr EventHeader
+	if err := hdr.UnmarshalBinary(sample); err != nil {
+		return hdr, err
+	}
+
+	r.mu.RLock()
+	dec, ok := r.decoders[eventKind{hdr.Module, hdr.Type}]
+	r.mu.RUnlock()
+	if !ok {
+		return hdr, fmt.Errorf("%w: %s type %d", ErrUnknownEvent, hdr.Module, hdr.Type)
+	}
+	return hdr, dec(hdr, hdr.Payload(sample), out)
+}
+
+// Handler adapts handle to an EventReader handler. Events of unknown
+// types are counted in flow_lens_agent_unknown_events_total and skipped.
+func (r *EventRegistry[T]) Handler(source string, handle func(EventHeader, *T) error) func([]byte) error {
+	unknown := AgentUnknownEvents.WithLabelValues(source)
+	return func(sample []byte) error {
+		var out T
+		hdr, err := r.Decode(sample, &out)
+		if errors.Is(err, ErrUnknownEvent) {
+			unknown.Inc()
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		return handle(hdr, &out)
+	}
+}
@@ -0,0 +1,165 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// header builds an event of module/typ with a hdrLen byte header and
+// payload, padded by pad bytes as perf buffers do.
+func header(typ uint16, hdrLen int, payload []byte, pad int) []byte {
+	data := make([]byte, hdrLen+len(payload)+pad)
+	data[0] = EventSchemaVersion
+	data[1] = byte(hdrLen)
+	data[2] = byte(ModuleTCPMonitor)
+	binary.LittleEndian.PutUint16(data[4:], typ)
+	binary.LittleEndian.PutUint16(data[6:], uint16(hdrLen+len(payload)))
+	binary.LittleEndian.PutUint64(data[8:], 1000)
+	binary.LittleEndian.PutUint64(data[16:], 77)
+	binary.LittleEndian.PutUint32(data[24:], 4026531840)
+	binary.LittleEndian.PutUint32(data[28:], 42)
+	copy(data[hdrLen:], payload)
+	return data
+}
+
+func TestEventHeader(t *testing.T) {
+	data := header(3, EventHeaderSize, []byte{1, 2}, 6)
+
+	var hdr EventHeader
+	if err := hdr.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	want := EventHeader{
+		Version:   EventSchemaVersion,
+		HdrLen:    EventHeaderSize,
+		Module:    ModuleTCPMonitor,
+		Type:      3,
+		Len:       EventHeaderSize + 2,
+		Timestamp: 1000,
+		Cgroup:    77,
+		Netns:     4026531840,
+		PID:       42,
+	}
+	if hdr != want {
+		t.Fatalf("got %+v, want %+v", hdr, want)
+	}
+	if got := hdr.Payload(data); string(got) != "\x01\x02" {
+		t.Fatalf("payload = %v, want the 2 bytes without padding", got)
+	}
+}
+
+func TestEventHeaderNewerVersion(t *testing.T) {
+	// A newer header with fields appended still decodes, and the payload
+	// starts after all of it.
+	data := header(1, EventHeaderSize+8, []byte{9}, 0)
+	data[0] = EventSchemaVersion + 1
+
+	var hdr EventHeader
+	if err := hdr.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got := hdr.Payload(data); len(got) != 1 || got[0] != 9 {
+		t.Fatalf("payload = %v, want [9]", got)
+	}
+}
+
+func TestEventHeaderInvalid(t *testing.T) {
+	tests := map[string]func([]byte){
+		"no version":         func(d []byte) { d[0] = 0 },
+		"hdr_len too small":  func(d []byte) { d[1] = 16 },
+		"len past sample":    func(d []byte) { d[6] = 200 },
+		"len before payload": func(d []byte) { d[6] = 8 },
+	}
+
+	var hdr EventHeader
+	if err := hdr.UnmarshalBinary(make([]byte, EventHeaderSize-1)); err == nil {
+		t.Fatalf("short: expected an error")
+	}
+	for name, corrupt := range tests {
+		data := header(1, EventHeaderSize, nil, 0)
+		corrupt(data)
+		if err := hdr.UnmarshalBinary(data); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEventRegistryHandler(t *testing.T) {
+	registry := NewEventRegistry[int]()
+	registry.Register(ModuleTCPMonitor, 1, func(hdr EventHeader, payload []byte, out *int) error {
+		*out = int(payload[0]) + int(hdr.PID)
+		return nil
+	})
+
+	var got []int
+	handler := registry.Handler("test-schema", func(_ EventHeader, v *int) error {
+		got = append(got, *v)
+		return nil
+	})
+
+	if err := handler(header(1, EventHeaderSize, []byte{5}, 0)); err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	if err := handler(header(2, EventHeaderSize, []byte{5}, 0)); err != nil {
+		t.Fatalf("unknown types should be skipped, got %v", err)
+	}
+	if err := handler(make([]byte, 4)); err == nil {
+		t.Fatalf("expected an error for a broken sample")
+	}
+
+	if len(got) != 1 || got[0] != 47 {
+		t.Fatalf("handled %v, want [47]", got)
+	}
+	if n := testutil.ToFloat64(AgentUnknownEvents.WithLabelValues("test-schema")); n != 1 {
+		t.Fatalf("unknown events = %v, want 1", n)
+	}
+}
+
+// TestEventHeaderLayout compares struct flow_event_header with the decoder,
+// using the tcpmonitor object built by make ebpf.
+func TestEventHeaderLayout(t *testing.T) {
+	const object = "../../bpf/tcpmonitor/tcp_monitor.o"
+	if _, err := os.Stat(object); errors.Is(err, os.ErrNotExist) {
+		t.Skipf("%s not built, run make ebpf", object)
+	}
+
+	spec, err := btf.LoadSpec(object)
+	if err != nil {
+		t.Fatalf("load BTF: %v", err)
+	}
+	var hdr *btf.Struct
+	if err := spec.TypeByName("flow_event_header", &hdr); err != nil {
+		t.Fatalf("find struct flow_event_header: %v", err)
+	}
+
+	want := map[string][2]int{ // offset, size
+		"version":   {0, 1},
+		"hdr_len":   {1, 1},
+		"module":    {2, 1},
+		"_pad":      {3, 1},
+		"type":      {4, 2},
+		"len":       {6, 2},
+		"timestamp": {8, 8},
+		"cgroup":    {16, 8},
+		"netns":     {24, 4},
+		"pid":       {28, 4},
+	}
+	if int(hdr.Size) != EventHeaderSize || len(hdr.Members) != len(want) {
+		t.Fatalf("struct flow_event_header is %d bytes with %d fields, want %d with %d", hdr.Size, len(hdr.Members), EventHeaderSize, len(want))
+	}
+	for _, member := range hdr.Members {
+		size, err := btf.Sizeof(member.Type)
+		if err != nil {
+			t.Fatalf("size of flow_event_header.%s: %v", member.Name, err)
+		}
+		got := [2]int{int(member.Offset.Bytes()), size}
+		if got != want[member.Name] {
+			t.Errorf("flow_event_header.%s at %d (%d bytes), decoder expects %v", member.Name, got[0], got[1], want[member.Name])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package conntrack
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	ct "github.com/ti-mo/conntrack"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultTTL        = 30 * time.Second
+	defaultMaxEntries = 65536
+)
+
+// Tuple identifies a TCP flow from the point of view of the socket that
+// opened it.
+type Tuple struct {
+	SrcIP   netip.Addr
+	DstIP   netip.Addr
+	SrcPort uint16
+	DstPort uint16
+}
+
+// Translation describes how conntrack rewrote the destination of a flow.
+type Translation struct {
+	// ServiceIP is the original destination (e.g. a ClusterIP). Empty when
+	// the flow was not DNATed.
+	ServiceIP string
+	// BackendIP is the destination after DNAT, or the original destination
+	// when no translation applies.
+	BackendIP string
+}
+
+type flowGetter interface {
+	Get(f ct.Flow) (ct.Flow, error)
+}
+
+type cacheEntry struct {
+	translation Translation
+	expires     time.Time
+}
+
+// Resolver looks up flows in the host conntrack table over netlink and
+// caches the answer for a short TTL, so retransmit storms on one flow cost
+// a single round trip.
+type Resolver struct {
+	conn       flowGetter
+	closer     func() error
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[Tuple]cacheEntry
+}
+
+// NewResolver opens a ctnetlink socket in the current network namespace.
+func NewResolver() (*Resolver, error) {
+	conn, err := ct.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial conntrack: %w", err)
+	}
+	r := newResolver(conn)
+	r.closer = conn.Close
+	return r, nil
+}
+
+func newResolver(conn flowGetter) *Resolver {
+	return &Resolver{
+		conn:       conn,
+		ttl:        defaultTTL,
+		maxEntries: defaultMaxEntries,
+		now:        time.Now,
+		entries:    make(map[Tuple]cacheEntry),
+	}
+}
+
+// Lookup returns the service/backend view of a TCP flow. It never fails:
+// when conntrack has no entry the destination is reported as the backend.
+func (r *Resolver) Lookup(t Tuple) Translation {
+	now := r.now()
+
+	r.mu.Lock()
+	if e, ok := r.entries[t]; ok && now.Before(e.expires) {
+		r.mu.Unlock()
+		return e.translation
+	}
+	r.mu.Unlock()
+
+	translation := r.query(t)
+
+	r.mu.Lock()
+	if len(r.entries) >= r.maxEntries {
+		r.evictLocked(now)
+	}
+	r.entries[t] = cacheEntry{translation: translation, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+
+	return translation
+}
+
+// Close releases the netlink socket.
+func (r *Resolver) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer()
+}
+
+func (r *Resolver) query(t Tuple) Translation {
+	untranslated := Translation{BackendIP: addrString(t.DstIP)}
+	if !t.SrcIP.IsValid() || !t.DstIP.IsValid() {
+		return untranslated
+	}
+
+	flow, err := r.conn.Get(ct.Flow{
+		TupleOrig: ct.Tuple{
+			IP: ct.IPTuple{SourceAddress: t.SrcIP, DestinationAddress: t.DstIP},
+			Proto: ct.ProtoTuple{
+				Protocol:        unix.IPPROTO_TCP,
+				SourcePort:      t.SrcPort,
+				DestinationPort: t.DstPort,
+			},
+		},
+	})
+	if err != nil {
+		if !errors.Is(err, unix.ENOENT) {
+			log.Printf("[conntrack] get %s:%d -> %s:%d: %v", t.SrcIP, t.SrcPort, t.DstIP, t.DstPort, err)
+		}
+		return untranslated
+	}
+	return translate(flow)
+}
+
+// translate compares the original destination with the source of the
+// reply direction: they differ exactly when the flow was DNATed.
+func translate(flow ct.Flow) Translation {
+	orig := flow.TupleOrig.IP.DestinationAddress
+	backend := flow.TupleReply.IP.SourceAddress
+
+	if !backend.IsValid() || backend == orig {
+		return Translation{BackendIP: addrString(orig)}
+	}
+	return Translation{ServiceIP: addrString(orig), BackendIP: addrString(backend)}
+}
+
+func (r *Resolver) evictLocked(now time.Time) {
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+		}
+	}
+	if len(r.entries) >= r.maxEntries {
+		r.entries = make(map[Tuple]cacheEntry)
+	}
+}
+
+func addrString(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
+	}
+	return a.String()
+}
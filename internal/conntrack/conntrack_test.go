@@ -0,0 +1,108 @@
+package conntrack
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	ct "github.com/ti-mo/conntrack"
+	"golang.org/x/sys/unix"
+)
+
+type fakeConntrack struct {
+	flows map[netip.Addr]ct.Flow // keyed by original destination
+	calls int
+	err   error
+}
+
+func (f *fakeConntrack) Get(q ct.Flow) (ct.Flow, error) {
+	f.calls++
+	if f.err != nil {
+		return ct.Flow{}, f.err
+	}
+	flow, ok := f.flows[q.TupleOrig.IP.DestinationAddress]
+	if !ok {
+		return ct.Flow{}, unix.ENOENT
+	}
+	return flow, nil
+}
+
+func dnatFlow(src, svc, backend string) ct.Flow {
+	return ct.Flow{
+		TupleOrig: ct.Tuple{IP: ct.IPTuple{
+			SourceAddress:      netip.MustParseAddr(src),
+			DestinationAddress: netip.MustParseAddr(svc),
+		}},
+		TupleReply: ct.Tuple{IP: ct.IPTuple{
+			SourceAddress:      netip.MustParseAddr(backend),
+			DestinationAddress: netip.MustParseAddr(src),
+		}},
+	}
+}
+
+func tuple(src, dst string) Tuple {
+	return Tuple{
+		SrcIP:   netip.MustParseAddr(src),
+		DstIP:   netip.MustParseAddr(dst),
+		SrcPort: 40000,
+		DstPort: 80,
+	}
+}
+
+func TestResolverLookup(t *testing.T) {
+	fake := &fakeConntrack{flows: map[netip.Addr]ct.Flow{
+		netip.MustParseAddr("10.96.0.10"): dnatFlow("10.0.0.5", "10.96.0.10", "10.0.1.7"),
+		netip.MustParseAddr("10.0.2.2"):   dnatFlow("10.0.0.5", "10.0.2.2", "10.0.2.2"),
+	}}
+	r := newResolver(fake)
+
+	tests := []struct {
+		name string
+		in   Tuple
+		want Translation
+	}{
+		{"service dnat", tuple("10.0.0.5", "10.96.0.10"), Translation{ServiceIP: "10.96.0.10", BackendIP: "10.0.1.7"}},
+		{"no dnat", tuple("10.0.0.5", "10.0.2.2"), Translation{BackendIP: "10.0.2.2"}},
+		{"not tracked", tuple("10.0.0.5", "1.1.1.1"), Translation{BackendIP: "1.1.1.1"}},
+	}
+
+	for _, tt := range tests {
+		if got := r.Lookup(tt.in); got != tt.want {
+			t.Fatalf("%s: Lookup = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolverCachesUntilTTL(t *testing.T) {
+	fake := &fakeConntrack{err: errors.New("netlink down")}
+	r := newResolver(fake)
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	in := tuple("10.0.0.5", "10.96.0.10")
+	r.Lookup(in)
+	r.Lookup(in)
+	if fake.calls != 1 {
+		t.Fatalf("expected cached second lookup, got %d queries", fake.calls)
+	}
+
+	now = now.Add(defaultTTL)
+	r.Lookup(in)
+	if fake.calls != 2 {
+		t.Fatalf("expected expired entry to be re-queried, got %d queries", fake.calls)
+	}
+}
+
+func TestResolverBoundsCache(t *testing.T) {
+	r := newResolver(&fakeConntrack{})
+	r.maxEntries = 2
+
+	r.Lookup(tuple("10.0.0.1", "10.0.0.9"))
+	r.Lookup(tuple("10.0.0.2", "10.0.0.9"))
+	r.Lookup(tuple("10.0.0.3", "10.0.0.9"))
+
+	if got := len(r.entries); got > r.maxEntries {
+		t.Fatalf("cache grew past its bound: %d entries", got)
+	}
+}
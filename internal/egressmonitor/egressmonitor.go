@@ -0,0 +1,329 @@
+package egressmonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/enrich"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+const (
+	defaultInterfacePrefixes = "veth,cali,lxc,gke,eni,azv"
+	defaultCIDRs             = "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"
+
+	pollInterval = 10 * time.Second
+)
+
+// Manager accounts pod egress bytes/packets per destination CIDR bucket by
+// attaching a TC program to the host side of every pod veth.
+type Manager struct {
+	Collection *ebpf.Collection
+
+	// InterfacePrefixes selects the host interfaces to attach to.
+	// Defaults to $EGRESS_INTERFACE_PREFIXES or common CNI veth prefixes.
+	InterfacePrefixes []string
+	// CIDRs are the destination buckets. Defaults to $EGRESS_CIDRS or RFC1918.
+	CIDRs []*net.IPNet
+	// Enrichers attribute interfaces to pods through their ifindex; nil
+	// leaves pod labels unknown.
+	Enrichers *enrich.Chain
+
+	mu       sync.Mutex
+	links    map[int]io.Closer
+	ifNames  map[int]string
+	last     map[egressKey]egressValue
+	buckets  map[uint32]string
+	interval time.Duration
+}
+
+type egressKey struct {
+	Ifindex uint32
+	Bucket  uint32
+}
+
+type egressValue struct {
+	Bytes   uint64
+	Packets uint64
+}
+
+type cidrKey struct {
+	Prefixlen uint32
+	Addr      [4]byte
+}
+
+// Load opens the BPF object, validates the program and fills the CIDR map.
+func (m *Manager) Load(objFileName string) error {
+	if len(m.InterfacePrefixes) == 0 {
+		m.InterfacePrefixes = splitList(envOrDefault("EGRESS_INTERFACE_PREFIXES", defaultInterfacePrefixes))
+	}
+	if len(m.CIDRs) == 0 {
+		cidrs, err := parseCIDRs(envOrDefault("EGRESS_CIDRS", defaultCIDRs))
+		if err != nil {
+			return err
+		}
+		m.CIDRs = cidrs
+	}
+
+	coll, err := common.LoadObjects(objFileName)
+	if err != nil {
+		return err
+	}
+
+	if coll.Programs["tc__egress_account"] == nil || coll.Maps["egress_stats"] == nil || coll.Maps["egress_cidrs"] == nil {
+		coll.Close()
+		return fmt.Errorf("missing required egress programs or maps in %s", objFileName)
+	}
+
+	buckets, err := writeCIDRs(coll.Maps["egress_cidrs"], m.CIDRs)
+	if err != nil {
+		coll.Close()
+		return err
+	}
+
+	m.Collection = coll
+	m.buckets = buckets
+	m.links = map[int]io.Closer{}
+	m.ifNames = map[int]string{}
+	m.last = map[egressKey]egressValue{}
+	m.interval = pollInterval
+	return nil
+}
+
+// Attach hooks every matching host interface that exists right now. Run
+// picks up interfaces created later.
+func (m *Manager) Attach() error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+	return m.syncInterfaces()
+}
+
+// Run periodically drains the per-cpu counters into Prometheus and keeps
+// the set of attached interfaces in sync with the host.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+	fmt.Println("Egress monitor running")
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := m.syncInterfaces(); err != nil {
+				log.Printf("[egressmonitor] sync interfaces: %v", err)
+			}
+			if err := m.collect(ctx); err != nil {
+				log.Printf("[egressmonitor] collect: %v", err)
+			}
+		}
+	}
+}
+
+// Close detaches every TC hook and closes the collection.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for ifindex, ln := range m.links {
+		if err := ln.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(m.links, ifindex)
+	}
+
+	if m.Collection != nil {
+		m.Collection.Close()
+		m.Collection = nil
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) syncInterfaces() error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("list interfaces: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prog := m.Collection.Programs["tc__egress_account"]
+	seen := make(map[int]struct{}, len(ifaces))
+
+	for _, iface := range ifaces {
+		if !hasAnyPrefix(iface.Name, m.InterfacePrefixes) {
+			continue
+		}
+		seen[iface.Index] = struct{}{}
+		if _, ok := m.links[iface.Index]; ok {
+			continue
+		}
+
+		ln, err := common.AttachTCIndex(iface.Index, prog, common.TCIngress)
+		if err != nil {
+			log.Printf("[egressmonitor] attach %s: %v", iface.Name, err)
+			continue
+		}
+		m.links[iface.Index] = ln
+		m.ifNames[iface.Index] = iface.Name
+	}
+
+	// Interfaces that vanished take their tc hooks with them; just drop the fds.
+	for ifindex, ln := range m.links {
+		if _, ok := seen[ifindex]; ok {
+			continue
+		}
+		_ = ln.Close()
+		delete(m.links, ifindex)
+		delete(m.ifNames, ifindex)
+	}
+	return nil
+}
+
+func (m *Manager) collect(ctx context.Context) error {
+	stats := m.Collection.Maps["egress_stats"]
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		key     egressKey
+		perCPU  []egressValue
+		current = make(map[egressKey]egressValue)
+		stale   []egressKey
+	)
+
+	iter := stats.Iterate()
+	for iter.Next(&key, &perCPU) {
+		if _, ok := m.links[int(key.Ifindex)]; !ok {
+			stale = append(stale, key)
+			continue
+		}
+		current[key] = sumPerCPU(perCPU)
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate egress stats: %w", err)
+	}
+
+	for k, v := range current {
+		delta := valueDelta(m.last[k], v)
+		recordEgress(m.ifNames[int(k.Ifindex)], m.pod(ctx, k.Ifindex), m.buckets[k.Bucket], delta)
+	}
+	m.last = current
+
+	for _, k := range stale {
+		if err := stats.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Printf("[egressmonitor] delete stale key %+v: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// pod returns the pod behind a host interface, empty when unknown.
+func (m *Manager) pod(ctx context.Context, ifindex uint32) sock.ContainerInfo {
+	evt := enrich.Event{Ifindex: int(ifindex)}
+	if m.Enrichers != nil {
+		m.Enrichers.Enrich(ctx, &evt)
+	}
+	return evt.Source
+}
+
+// writeCIDRs fills the LPM trie and returns bucket id → label. Bucket 0 is
+// the catch-all for destinations outside every configured CIDR.
+func writeCIDRs(lpm *ebpf.Map, cidrs []*net.IPNet) (map[uint32]string, error) {
+	buckets := map[uint32]string{0: otherBucket}
+	for i, cidr := range cidrs {
+		ip4 := cidr.IP.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("egress cidr %s: only IPv4 is supported", cidr)
+		}
+		ones, _ := cidr.Mask.Size()
+
+		key := cidrKey{Prefixlen: uint32(ones)}
+		copy(key.Addr[:], ip4)
+
+		bucket := uint32(i + 1)
+		if err := lpm.Put(key, bucket); err != nil {
+			return nil, fmt.Errorf("put egress cidr %s: %w", cidr, err)
+		}
+		buckets[bucket] = cidr.String()
+	}
+	return buckets, nil
+}
+
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, item := range splitList(value) {
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("parse egress cidr %q: %w", item, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+func sumPerCPU(values []egressValue) egressValue {
+	var total egressValue
+	for _, v := range values {
+		total.Bytes += v.Bytes
+		total.Packets += v.Packets
+	}
+	return total
+}
+
+// valueDelta returns the increase since the previous read. A smaller value
+// means the map entry was recreated, so the whole current value is new.
+func valueDelta(prev, cur egressValue) egressValue {
+	if cur.Bytes < prev.Bytes || cur.Packets < prev.Packets {
+		return cur
+	}
+	return egressValue{
+		Bytes:   cur.Bytes - prev.Bytes,
+		Packets: cur.Packets - prev.Packets,
+	}
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,64 @@
+package egressmonitor
+
+import (
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/sock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const otherBucket = "other"
+
+var egressLabels = []string{
+	"interface",
+	"target_pod",
+	"target_namespace",
+	"destination_cidr",
+}
+
+var (
+	EgressBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "egress",
+			Name:      "bytes_total",
+			Help:      "Bytes sent by pods labeled by host interface and destination CIDR bucket",
+		},
+		egressLabels,
+	)
+
+	EgressPackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "egress",
+			Name:      "packets_total",
+			Help:      "Packets sent by pods labeled by host interface and destination CIDR bucket",
+		},
+		egressLabels,
+	)
+)
+
+func labelOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func recordEgress(ifName string, info sock.ContainerInfo, bucket string, delta egressValue) {
+	if delta.Bytes == 0 && delta.Packets == 0 {
+		return
+	}
+	labels := []string{
+		labelOrUnknown(ifName),
+		labelOrUnknown(info.PodName),
+		labelOrUnknown(info.Namespace),
+		labelOrUnknown(bucket),
+	}
+	EgressBytes.WithLabelValues(labels...).Add(float64(delta.Bytes))
+	EgressPackets.WithLabelValues(labels...).Add(float64(delta.Packets))
+}
+
+func init() {
+	common.RegisterMetric(EgressBytes)
+	common.RegisterMetric(EgressPackets)
+}
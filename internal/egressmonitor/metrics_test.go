@@ -0,0 +1,60 @@
+package egressmonitor
+
+import (
+	"testing"
+
+	"github.com/net-lens/flow-lens/internal/sock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValueDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur egressValue
+		want      egressValue
+	}{
+		{"first read", egressValue{}, egressValue{Bytes: 100, Packets: 2}, egressValue{Bytes: 100, Packets: 2}},
+		{"increase", egressValue{Bytes: 100, Packets: 2}, egressValue{Bytes: 250, Packets: 5}, egressValue{Bytes: 150, Packets: 3}},
+		{"entry recreated", egressValue{Bytes: 100, Packets: 2}, egressValue{Bytes: 40, Packets: 1}, egressValue{Bytes: 40, Packets: 1}},
+	}
+
+	for _, tt := range tests {
+		if got := valueDelta(tt.prev, tt.cur); got != tt.want {
+			t.Fatalf("%s: valueDelta = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	cidrs, err := parseCIDRs(" 10.0.0.0/8, ,192.168.1.7/24")
+	if err != nil {
+		t.Fatalf("parseCIDRs returned error: %v", err)
+	}
+	if len(cidrs) != 2 || cidrs[0].String() != "10.0.0.0/8" || cidrs[1].String() != "192.168.1.0/24" {
+		t.Fatalf("unexpected cidrs: %v", cidrs)
+	}
+
+	if _, err := parseCIDRs("10.0.0.0/33"); err == nil {
+		t.Fatalf("expected error for invalid cidr")
+	}
+}
+
+func TestRecordEgress(t *testing.T) {
+	EgressBytes.Reset()
+	EgressPackets.Reset()
+
+	info := sock.ContainerInfo{Namespace: "ns", PodName: "pod"}
+	recordEgress("veth1", info, "10.0.0.0/8", egressValue{Bytes: 1500, Packets: 1})
+	recordEgress("veth1", info, "10.0.0.0/8", egressValue{Bytes: 500, Packets: 1})
+	recordEgress("veth2", sock.ContainerInfo{}, otherBucket, egressValue{})
+
+	if got := testutil.ToFloat64(EgressBytes.WithLabelValues("veth1", "pod", "ns", "10.0.0.0/8")); got != 2000 {
+		t.Fatalf("expected 2000 bytes, got %v", got)
+	}
+	if got := testutil.ToFloat64(EgressPackets.WithLabelValues("veth1", "pod", "ns", "10.0.0.0/8")); got != 2 {
+		t.Fatalf("expected 2 packets, got %v", got)
+	}
+	if got := testutil.CollectAndCount(EgressBytes); got != 1 {
+		t.Fatalf("expected zero deltas to create no series, got %d series", got)
+	}
+}
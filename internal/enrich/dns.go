@@ -0,0 +1,155 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDNSTimeout = 100 * time.Millisecond
+	DefaultDNSTTL     = 5 * time.Minute
+
+	dnsMaxEntries = 4096
+	// dnsMaxInflight bounds the lookups running in the background; misses
+	// beyond it are looked up by a later event.
+	dnsMaxInflight = 64
+	// dnsFailureTTL keeps a failing resolver from being asked again for
+	// every event to the same destination.
+	dnsFailureTTL = 30 * time.Second
+)
+
+type dnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// DNSEnricher sets DestinationName from a PTR lookup of the (backend)
+// destination. It never waits for the resolver: a miss starts a lookup in
+// the background and the event goes on without a name, so only events
+// after the answer carry it. Answers, including "no name", are cached for
+// TTL; an expired name is still served while it is refreshed. Failed
+// lookups keep the previous name and are retried after dnsFailureTTL.
+type DNSEnricher struct {
+	LookupAddr func(ctx context.Context, addr string) ([]string, error)
+	Timeout    time.Duration
+	TTL        time.Duration
+
+	now      func() time.Time
+	mu       sync.Mutex
+	entries  map[string]dnsEntry
+	inflight map[string]struct{}
+	// pending tracks the background lookups, for tests.
+	pending sync.WaitGroup
+}
+
+// DNS uses the system resolver.
+func DNS(timeout, ttl time.Duration) *DNSEnricher {
+	return &DNSEnricher{LookupAddr: net.DefaultResolver.LookupAddr, Timeout: timeout, TTL: ttl}
+}
+
+func (*DNSEnricher) Name() string { return "dns" }
+
+func (e *DNSEnricher) Enrich(_ context.Context, evt *Event) error {
+	addr := evt.Translation.BackendIP
+	if addr == "" && evt.Dst.IsValid() {
+		addr = evt.Dst.String()
+	}
+	if _, err := netip.ParseAddr(addr); err != nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry, ok := e.entries[addr]
+	evt.DestinationName = entry.name
+	if ok && e.clock().Before(entry.expires) {
+		return nil
+	}
+	if _, running := e.inflight[addr]; running || len(e.inflight) >= dnsMaxInflight {
+		return nil
+	}
+	if e.inflight == nil {
+		e.inflight = map[string]struct{}{}
+	}
+	e.inflight[addr] = struct{}{}
+	e.pending.Add(1)
+	go e.refresh(addr)
+	return nil
+}
+
+// refresh looks addr up and caches the answer. It runs outside the chain,
+// so it counts and logs its own failures.
+func (e *DNSEnricher) refresh(addr string) {
+	defer e.pending.Done()
+
+	name, err := e.lookup(context.Background(), addr)
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = DefaultDNSTTL
+	}
+	if err != nil {
+		ttl = dnsFailureTTL
+		enricherErrors.WithLabelValues(e.Name()).Inc()
+		log.Printf("[enrich] dns: %v", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.inflight, addr)
+	if err != nil {
+		name = e.entries[addr].name
+	}
+	e.rememberLocked(addr, name, e.clock().Add(ttl))
+}
+
+// lookup asks the resolver for the name of addr. "No name" is not an
+// error.
+func (e *DNSEnricher) lookup(ctx context.Context, addr string) (string, error) {
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	names, err := e.LookupAddr(ctx, addr)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	return strings.TrimSuffix(names[0], "."), nil
+}
+
+func (e *DNSEnricher) rememberLocked(addr, name string, expires time.Time) {
+	now := e.clock()
+	if e.entries == nil {
+		e.entries = map[string]dnsEntry{}
+	}
+	if len(e.entries) >= dnsMaxEntries {
+		for k, entry := range e.entries {
+			if !now.Before(entry.expires) {
+				delete(e.entries, k)
+			}
+		}
+		if len(e.entries) >= dnsMaxEntries {
+			e.entries = map[string]dnsEntry{}
+		}
+	}
+	e.entries[addr] = dnsEntry{name: name, expires: expires}
+}
+
+func (e *DNSEnricher) clock() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
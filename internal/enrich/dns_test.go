@@ -0,0 +1,173 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// enrichName runs e on an event to dst and returns the name it set.
+func enrichName(t *testing.T, e *DNSEnricher, dst string) string {
+	t.Helper()
+	evt := Event{Dst: netip.MustParseAddr(dst)}
+	if err := e.Enrich(context.Background(), &evt); err != nil {
+		t.Fatalf("Enrich: %v", err)
+	}
+	return evt.DestinationName
+}
+
+func TestDNSEnricherCachesAnswers(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var mu sync.Mutex
+	lookups := map[string]int{}
+	e := &DNSEnricher{
+		LookupAddr: func(_ context.Context, addr string) ([]string, error) {
+			mu.Lock()
+			lookups[addr]++
+			mu.Unlock()
+			if addr == "10.0.0.9" {
+				return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+			}
+			return []string{"db.example.com."}, nil
+		},
+		TTL: time.Minute,
+		now: func() time.Time { return now },
+	}
+
+	if name := enrichName(t, e, "10.0.0.5"); name != "" {
+		t.Fatalf("DestinationName = %q before the lookup finished", name)
+	}
+	enrichName(t, e, "10.0.0.9")
+	e.pending.Wait()
+
+	for i := 0; i < 2; i++ {
+		if name := enrichName(t, e, "10.0.0.5"); name != "db.example.com" {
+			t.Fatalf("DestinationName = %q", name)
+		}
+		if name := enrichName(t, e, "10.0.0.9"); name != "" {
+			t.Fatalf("DestinationName = %q, want empty", name)
+		}
+	}
+	e.pending.Wait()
+	if lookups["10.0.0.5"] != 1 || lookups["10.0.0.9"] != 1 {
+		t.Fatalf("lookups = %v, want one per address", lookups)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if name := enrichName(t, e, "10.0.0.5"); name != "db.example.com" {
+		t.Fatalf("expired name %q was not served while refreshing", name)
+	}
+	e.pending.Wait()
+	if lookups["10.0.0.5"] != 2 {
+		t.Fatalf("expired entry was not looked up again")
+	}
+}
+
+func TestDNSEnricherPrefersBackend(t *testing.T) {
+	var asked string
+	e := &DNSEnricher{LookupAddr: func(_ context.Context, addr string) ([]string, error) {
+		asked = addr
+		return []string{"backend."}, nil
+	}}
+	evt := Event{Dst: netip.MustParseAddr("10.96.0.10")}
+	evt.Translation.BackendIP = "10.0.1.5"
+	e.Enrich(context.Background(), &evt)
+	e.pending.Wait()
+	if asked != "10.0.1.5" {
+		t.Fatalf("looked up %q, want the backend", asked)
+	}
+}
+
+func TestDNSEnricherCachesFailuresBriefly(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var calls atomic.Int32
+	e := &DNSEnricher{
+		LookupAddr: func(ctx context.Context, _ string) ([]string, error) {
+			calls.Add(1)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		Timeout: 10 * time.Millisecond,
+		now:     func() time.Time { return now },
+	}
+	errors := enricherErrors.WithLabelValues("dns")
+	before := testutil.ToFloat64(errors)
+
+	enrichName(t, e, "10.0.0.5")
+	e.pending.Wait()
+	if got := testutil.ToFloat64(errors) - before; got != 1 {
+		t.Fatalf("recorded %v dns errors, want 1", got)
+	}
+	if name := enrichName(t, e, "10.0.0.5"); name != "" {
+		t.Fatalf("cached failure = %q, want no name", name)
+	}
+	e.pending.Wait()
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("failed lookup was not cached: %d calls", got)
+	}
+
+	now = now.Add(dnsFailureTTL)
+	enrichName(t, e, "10.0.0.5")
+	e.pending.Wait()
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("failed lookup was not retried after %v: %d calls", dnsFailureTTL, got)
+	}
+}
+
+func TestDNSEnricherDoesNotWait(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	e := &DNSEnricher{
+		LookupAddr: func(context.Context, string) ([]string, error) {
+			calls.Add(1)
+			<-release
+			return []string{"db.example.com."}, nil
+		},
+		Timeout: time.Minute,
+	}
+
+	// Every event returns while the first lookup is still blocked, and
+	// none of them starts another.
+	for i := 0; i < 8; i++ {
+		if name := enrichName(t, e, "10.0.0.5"); name != "" {
+			t.Fatalf("DestinationName = %q before the lookup finished", name)
+		}
+	}
+	close(release)
+	e.pending.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("%d lookups for one address, want 1", got)
+	}
+	if name := enrichName(t, e, "10.0.0.5"); name != "db.example.com" {
+		t.Fatalf("DestinationName = %q", name)
+	}
+}
+
+func TestDNSEnricherBoundsInflightLookups(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	e := &DNSEnricher{
+		LookupAddr: func(context.Context, string) ([]string, error) {
+			calls.Add(1)
+			<-release
+			return nil, nil
+		},
+		Timeout: time.Minute,
+	}
+
+	for i := 0; i < dnsMaxInflight+10; i++ {
+		enrichName(t, e, netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}).String())
+	}
+	close(release)
+	e.pending.Wait()
+	if got := calls.Load(); got != dnsMaxInflight {
+		t.Fatalf("%d lookups started, want at most %d", got, dnsMaxInflight)
+	}
+}
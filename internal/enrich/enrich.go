@@ -0,0 +1,189 @@
+// Package enrich attributes module events to workloads through an ordered
+// chain of enrichers: PID, cgroup, network namespace, host interface,
+// Kubernetes metadata, peer resolution and reverse DNS.
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/conntrack"
+	"github.com/net-lens/flow-lens/internal/peer"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+// DefaultEnrichers is the chain used when ENRICHERS is not set. dns is
+// left out because it costs a lookup per new destination.
+const DefaultEnrichers = "pid,cgroup,netns,interface,kubernetes,peer"
+
+// Names lists the known enrichers in their natural order.
+var Names = []string{"pid", "cgroup", "netns", "interface", "kubernetes", "peer", "dns"}
+
+// sourceNames are the enrichers that attribute or describe the source.
+var sourceNames = map[string]bool{"pid": true, "cgroup": true, "netns": true, "interface": true, "kubernetes": true}
+
+// Event is what the enrichers know about one kernel event. Modules fill
+// the kernel fields and read the rest back.
+type Event struct {
+	PID int
+	// CgroupID is the kernel cgroup id of PID, 0 when unknown.
+	CgroupID uint64
+	Netns    uint64
+	// Ifindex is the host interface of traffic counted on a pod veth, 0
+	// for socket events.
+	Ifindex int
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+
+	// Source is the workload that produced the event, valid once
+	// Attributed is set.
+	Source     sock.ContainerInfo
+	Attributed bool
+
+	// Translation is the pre- and post-DNAT view of Dst.
+	Translation conntrack.Translation
+	// Destination is the workload behind the (backend) destination.
+	Destination peer.Info
+	// DestinationName is the reverse DNS name of the destination.
+	DestinationName string
+}
+
+// Enricher adds what it knows to an event. Enrichers run in chain order
+// and see the results of the earlier ones; an error is recorded and the
+// chain goes on.
+type Enricher interface {
+	Name() string
+	Enrich(ctx context.Context, evt *Event) error
+}
+
+var (
+	enricherDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "enricher_duration_seconds",
+			Help:      "Time spent in one enricher per event",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 10),
+		},
+		[]string{"enricher"},
+	)
+
+	enricherErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "agent",
+			Name:      "enricher_errors_total",
+			Help:      "Enricher failures; the event goes on without that enrichment",
+		},
+		[]string{"enricher"},
+	)
+)
+
+func init() {
+	common.RegisterMetric(enricherDuration)
+	common.RegisterMetric(enricherErrors)
+}
+
+type stage struct {
+	Enricher
+	duration prometheus.Observer
+	errors   prometheus.Counter
+}
+
+// Chain runs enrichers in order.
+type Chain struct {
+	stages []stage
+}
+
+func NewChain(enrichers ...Enricher) *Chain {
+	c := &Chain{}
+	for _, e := range enrichers {
+		c.stages = append(c.stages, stage{
+			Enricher: e,
+			duration: enricherDuration.WithLabelValues(e.Name()),
+			errors:   enricherErrors.WithLabelValues(e.Name()),
+		})
+	}
+	return c
+}
+
+// Names returns the enrichers of the chain in order.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.stages))
+	for i, s := range c.stages {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// Has reports whether the chain runs the enricher called name.
+func (c *Chain) Has(name string) bool {
+	for _, s := range c.stages {
+		if s.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Enrich passes evt through every enricher.
+func (c *Chain) Enrich(ctx context.Context, evt *Event) {
+	c.run(ctx, evt, false)
+}
+
+// EnrichSource passes evt through the enrichers that attribute its source
+// only, for retrying events whose PID was not attributed yet without
+// repeating the destination lookups.
+func (c *Chain) EnrichSource(ctx context.Context, evt *Event) {
+	c.run(ctx, evt, true)
+}
+
+func (c *Chain) run(ctx context.Context, evt *Event, sourceOnly bool) {
+	for _, s := range c.stages {
+		if sourceOnly && !sourceNames[s.Name()] {
+			continue
+		}
+		start := time.Now()
+		err := s.Enrich(ctx, evt)
+		s.duration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			s.errors.Inc()
+			log.Printf("[enrich] %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// ParseNames parses a comma-separated, ordered list of enricher names.
+func ParseNames(value string) ([]string, error) {
+	known := map[string]bool{}
+	for _, name := range Names {
+		known[name] = true
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown enricher %q (known: %s)", name, strings.Join(Names, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("enricher %q listed twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
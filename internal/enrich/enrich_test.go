@@ -0,0 +1,94 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type recordingEnricher struct {
+	name string
+	err  error
+	seen *[]string
+}
+
+func (e recordingEnricher) Name() string { return e.name }
+
+func (e recordingEnricher) Enrich(_ context.Context, evt *Event) error {
+	*e.seen = append(*e.seen, e.name)
+	evt.DestinationName += e.name
+	return e.err
+}
+
+func TestChainRunsInOrderPastErrors(t *testing.T) {
+	var seen []string
+	c := NewChain(
+		recordingEnricher{name: "test-a", seen: &seen},
+		recordingEnricher{name: "test-b", seen: &seen, err: errors.New("boom")},
+		recordingEnricher{name: "test-c", seen: &seen},
+	)
+	if got := c.Names(); !reflect.DeepEqual(got, []string{"test-a", "test-b", "test-c"}) {
+		t.Fatalf("Names() = %v", got)
+	}
+
+	var evt Event
+	c.Enrich(context.Background(), &evt)
+
+	if !reflect.DeepEqual(seen, []string{"test-a", "test-b", "test-c"}) {
+		t.Fatalf("enrichers ran as %v", seen)
+	}
+	if evt.DestinationName != "test-atest-btest-c" {
+		t.Fatalf("later enrichers did not see earlier results: %q", evt.DestinationName)
+	}
+	if got := testutil.ToFloat64(enricherErrors.WithLabelValues("test-b")); got != 1 {
+		t.Fatalf("errors{test-b} = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(enricherErrors.WithLabelValues("test-a")); got != 0 {
+		t.Fatalf("errors{test-a} = %v, want 0", got)
+	}
+	if got := testutil.CollectAndCount(enricherDuration, "flow_lens_agent_enricher_duration_seconds"); got < 3 {
+		t.Fatalf("duration series = %d, want one per enricher", got)
+	}
+}
+
+func TestEnrichSourceSkipsDestinationEnrichers(t *testing.T) {
+	var seen []string
+	c := NewChain(
+		recordingEnricher{name: "pid", seen: &seen},
+		recordingEnricher{name: "peer", seen: &seen},
+		recordingEnricher{name: "kubernetes", seen: &seen},
+		recordingEnricher{name: "dns", seen: &seen},
+	)
+	if !c.Has("peer") || c.Has("cgroup") {
+		t.Fatalf("Has reports the wrong enrichers for %v", c.Names())
+	}
+
+	c.EnrichSource(context.Background(), &Event{})
+	if !reflect.DeepEqual(seen, []string{"pid", "kubernetes"}) {
+		t.Fatalf("source enrichers ran as %v", seen)
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	names, err := ParseNames(" dns, pid ,,peer")
+	if err != nil {
+		t.Fatalf("ParseNames: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"dns", "pid", "peer"}) {
+		t.Fatalf("ParseNames = %v, want the given order", names)
+	}
+
+	defaults, err := ParseNames(DefaultEnrichers)
+	if err != nil || len(defaults) != 6 {
+		t.Fatalf("ParseNames(DefaultEnrichers) = %v, %v", defaults, err)
+	}
+
+	for _, bad := range []string{"pid,geoip", "pid,pid"} {
+		if _, err := ParseNames(bad); err == nil {
+			t.Fatalf("ParseNames(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package enrich
+
+import (
+	"context"
+
+	"github.com/net-lens/flow-lens/internal/conntrack"
+	"github.com/net-lens/flow-lens/internal/peer"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+// PIDEnricher attributes events through the runtime's PID cache.
+type PIDEnricher struct {
+	Lookup func(pid int) (sock.ContainerInfo, bool)
+}
+
+// PID uses the PID cache filled by sock.InitRuntime.
+func PID() *PIDEnricher { return &PIDEnricher{Lookup: sock.CachedPID} }
+
+func (*PIDEnricher) Name() string { return "pid" }
+
+func (e *PIDEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Attributed || evt.PID <= 0 {
+		return nil
+	}
+	evt.Source, evt.Attributed = e.Lookup(evt.PID)
+	return nil
+}
+
+// CgroupEnricher attributes PIDs the runtime did not report, such as
+// workers and forked children, and host processes.
+type CgroupEnricher struct {
+	Resolve func(pid int, cgroupID uint64) (sock.ContainerInfo, bool)
+}
+
+// Cgroup reads /proc/<pid>/cgroup, or answers from the cgroup id when it
+// has been seen before.
+func Cgroup() *CgroupEnricher { return &CgroupEnricher{Resolve: sock.CgroupPID} }
+
+func (*CgroupEnricher) Name() string { return "cgroup" }
+
+func (e *CgroupEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Attributed || evt.PID <= 0 {
+		return nil
+	}
+	evt.Source, evt.Attributed = e.Resolve(evt.PID, evt.CgroupID)
+	return nil
+}
+
+// NetnsEnricher attributes events without a known PID, such as
+// retransmits from softirq context, to the pod owning their network
+// namespace.
+type NetnsEnricher struct {
+	Lookup func(ino uint64) (sock.ContainerInfo, bool)
+}
+
+// Netns scans the namespaces of the cached PIDs.
+func Netns() *NetnsEnricher { return &NetnsEnricher{Lookup: sock.NetnsPod} }
+
+func (*NetnsEnricher) Name() string { return "netns" }
+
+func (e *NetnsEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Attributed || evt.Netns == 0 {
+		return nil
+	}
+	evt.Source, evt.Attributed = e.Lookup(evt.Netns)
+	return nil
+}
+
+// InterfaceEnricher attributes traffic counted on a pod's host veth, as
+// egressmonitor and qdiscmonitor do, to the pod behind the interface.
+type InterfaceEnricher struct {
+	Lookup func(ifindex int) (sock.ContainerInfo, bool)
+}
+
+// Interface uses the veth index fed by the containerd watcher.
+func Interface() *InterfaceEnricher {
+	return &InterfaceEnricher{Lookup: sock.Interfaces().LookupIfindex}
+}
+
+func (*InterfaceEnricher) Name() string { return "interface" }
+
+func (e *InterfaceEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Attributed || evt.Ifindex <= 0 {
+		return nil
+	}
+	evt.Source, evt.Attributed = e.Lookup(evt.Ifindex)
+	return nil
+}
+
+// KubernetesEnricher completes the source with the configured metadata
+// providers and its owning workload.
+type KubernetesEnricher struct {
+	Describe func(sock.ContainerInfo) sock.ContainerInfo
+}
+
+// Kubernetes applies METADATA_PROVIDERS and the workload source.
+func Kubernetes() *KubernetesEnricher { return &KubernetesEnricher{Describe: sock.Describe} }
+
+func (*KubernetesEnricher) Name() string { return "kubernetes" }
+
+func (e *KubernetesEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Attributed {
+		evt.Source = e.Describe(evt.Source)
+	}
+	return nil
+}
+
+// ConntrackResolver maps a flow to its pre- and post-DNAT destination.
+type ConntrackResolver interface {
+	Lookup(t conntrack.Tuple) conntrack.Translation
+}
+
+// PeerResolver maps a remote IP to the pod/service behind it.
+type PeerResolver interface {
+	Lookup(ip string) (peer.Info, bool)
+}
+
+// PeerEnricher resolves the destination: conntrack undoes Service DNAT
+// and Peers names the pod and Service behind it. Either may be nil.
+type PeerEnricher struct {
+	Conntrack ConntrackResolver
+	Peers     PeerResolver
+}
+
+func (*PeerEnricher) Name() string { return "peer" }
+
+func (e *PeerEnricher) Enrich(_ context.Context, evt *Event) error {
+	if evt.Translation.BackendIP == "" && evt.Dst.IsValid() {
+		evt.Translation.BackendIP = evt.Dst.String()
+	}
+	// Aggregated events carry no source port to look the flow up with.
+	if e.Conntrack != nil && evt.SrcPort != 0 {
+		evt.Translation = e.Conntrack.Lookup(conntrack.Tuple{
+			SrcIP:   evt.Src,
+			DstIP:   evt.Dst,
+			SrcPort: evt.SrcPort,
+			DstPort: evt.DstPort,
+		})
+	}
+
+	if e.Peers == nil {
+		return nil
+	}
+	dest, _ := e.Peers.Lookup(evt.Translation.BackendIP)
+	if dest.Service == "" && evt.Translation.ServiceIP != "" {
+		if svc, ok := e.Peers.Lookup(evt.Translation.ServiceIP); ok {
+			dest.Service = svc.Service
+			if dest.Namespace == "" {
+				dest.Namespace = svc.Namespace
+			}
+		}
+	}
+	evt.Destination = dest
+	return nil
+}
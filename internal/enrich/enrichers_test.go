@@ -0,0 +1,152 @@
+package enrich
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/net-lens/flow-lens/internal/conntrack"
+	"github.com/net-lens/flow-lens/internal/peer"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+func TestSourceEnrichersStopOnceAttributed(t *testing.T) {
+	var calls []string
+	pid := &PIDEnricher{Lookup: func(int) (sock.ContainerInfo, bool) {
+		calls = append(calls, "pid")
+		return sock.ContainerInfo{}, false
+	}}
+	cgroup := &CgroupEnricher{Resolve: func(int, uint64) (sock.ContainerInfo, bool) {
+		calls = append(calls, "cgroup")
+		return sock.ContainerInfo{PodName: "web-0", ContainerID: "abc"}, true
+	}}
+	netns := &NetnsEnricher{Lookup: func(uint64) (sock.ContainerInfo, bool) {
+		calls = append(calls, "netns")
+		return sock.ContainerInfo{PodName: "other"}, true
+	}}
+	k8s := &KubernetesEnricher{Describe: func(info sock.ContainerInfo) sock.ContainerInfo {
+		calls = append(calls, "kubernetes")
+		info.Namespace = "shop"
+		return info
+	}}
+
+	evt := Event{PID: 42, Netns: 4026531840}
+	NewChain(pid, cgroup, netns, k8s).Enrich(context.Background(), &evt)
+
+	if want := []string{"pid", "cgroup", "kubernetes"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	if !evt.Attributed || evt.Source.PodName != "web-0" || evt.Source.Namespace != "shop" {
+		t.Fatalf("source = %+v attributed=%v", evt.Source, evt.Attributed)
+	}
+}
+
+func TestNetnsEnricherAttributesEventsWithoutPID(t *testing.T) {
+	e := &NetnsEnricher{Lookup: func(ino uint64) (sock.ContainerInfo, bool) {
+		return sock.ContainerInfo{PodName: "web-0"}, ino == 7
+	}}
+	pid := &PIDEnricher{Lookup: func(int) (sock.ContainerInfo, bool) {
+		t.Fatalf("pid lookup for an event without a PID")
+		return sock.ContainerInfo{}, false
+	}}
+
+	evt := Event{Netns: 7}
+	NewChain(pid, e).Enrich(context.Background(), &evt)
+	if !evt.Attributed || evt.Source.PodName != "web-0" {
+		t.Fatalf("source = %+v attributed=%v", evt.Source, evt.Attributed)
+	}
+
+	evt = Event{}
+	e.Enrich(context.Background(), &evt)
+	if evt.Attributed {
+		t.Fatalf("event without a netns was attributed")
+	}
+}
+
+func TestInterfaceEnricherAttributesVethTraffic(t *testing.T) {
+	e := &InterfaceEnricher{Lookup: func(ifindex int) (sock.ContainerInfo, bool) {
+		return sock.ContainerInfo{PodName: "web-0"}, ifindex == 12
+	}}
+
+	evt := Event{Ifindex: 12}
+	NewChain(e).Enrich(context.Background(), &evt)
+	if !evt.Attributed || evt.Source.PodName != "web-0" {
+		t.Fatalf("source = %+v attributed=%v", evt.Source, evt.Attributed)
+	}
+
+	evt = Event{PID: 42}
+	NewChain(e).Enrich(context.Background(), &evt)
+	if evt.Attributed {
+		t.Fatalf("event without an ifindex was attributed")
+	}
+}
+
+func TestKubernetesEnricherSkipsUnattributed(t *testing.T) {
+	e := &KubernetesEnricher{Describe: func(sock.ContainerInfo) sock.ContainerInfo {
+		t.Fatalf("describe called for an unattributed event")
+		return sock.ContainerInfo{}
+	}}
+	e.Enrich(context.Background(), &Event{PID: 1})
+}
+
+type fakeConntrack struct {
+	translation conntrack.Translation
+	tuples      []conntrack.Tuple
+}
+
+func (f *fakeConntrack) Lookup(t conntrack.Tuple) conntrack.Translation {
+	f.tuples = append(f.tuples, t)
+	return f.translation
+}
+
+type fakePeers map[string]peer.Info
+
+func (f fakePeers) Lookup(ip string) (peer.Info, bool) {
+	info, ok := f[ip]
+	return info, ok
+}
+
+func TestPeerEnricherUndoesDNAT(t *testing.T) {
+	ct := &fakeConntrack{translation: conntrack.Translation{ServiceIP: "10.96.0.10", BackendIP: "10.0.1.5"}}
+	e := &PeerEnricher{
+		Conntrack: ct,
+		Peers: fakePeers{
+			"10.0.1.5":   {Pod: "db-0", Namespace: "data"},
+			"10.96.0.10": {Service: "db", Namespace: "data"},
+		},
+	}
+
+	evt := Event{
+		Src:     netip.MustParseAddr("10.0.0.2"),
+		Dst:     netip.MustParseAddr("10.96.0.10"),
+		SrcPort: 40000,
+		DstPort: 5432,
+	}
+	if err := e.Enrich(context.Background(), &evt); err != nil {
+		t.Fatalf("Enrich: %v", err)
+	}
+
+	if len(ct.tuples) != 1 || ct.tuples[0].SrcPort != 40000 || ct.tuples[0].DstIP != evt.Dst {
+		t.Fatalf("conntrack tuples = %+v", ct.tuples)
+	}
+	want := peer.Info{Pod: "db-0", Namespace: "data", Service: "db"}
+	if evt.Destination != want {
+		t.Fatalf("destination = %+v, want %+v", evt.Destination, want)
+	}
+}
+
+func TestPeerEnricherWithoutSourcePort(t *testing.T) {
+	ct := &fakeConntrack{}
+	e := &PeerEnricher{Conntrack: ct, Peers: fakePeers{"10.0.1.5": {Pod: "db-0", Namespace: "data"}}}
+
+	evt := Event{Dst: netip.MustParseAddr("10.0.1.5")}
+	e.Enrich(context.Background(), &evt)
+
+	if len(ct.tuples) != 0 {
+		t.Fatalf("conntrack queried without a source port")
+	}
+	if evt.Translation.BackendIP != "10.0.1.5" || evt.Destination.Pod != "db-0" {
+		t.Fatalf("translation = %+v destination = %+v", evt.Translation, evt.Destination)
+	}
+}
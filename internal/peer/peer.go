@@ -0,0 +1,194 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	podIPIndex      = "podIP"
+	clusterIPIndex  = "clusterIP"
+	endpointIPIndex = "endpointIP"
+
+	defaultResync = 10 * time.Minute
+)
+
+// Info describes the workload behind a remote IP.
+type Info struct {
+	Pod       string
+	Namespace string
+	Service   string
+}
+
+// Resolver maps remote IPs to pods and services using cluster-wide Pod,
+// Service and EndpointSlice informers. It also serves the owning workload
+// of local pods, for which ReplicaSets are watched as well.
+type Resolver struct {
+	factory     informers.SharedInformerFactory
+	pods        cache.SharedIndexInformer
+	services    cache.SharedIndexInformer
+	slices      cache.SharedIndexInformer
+	replicaSets cache.SharedIndexInformer
+}
+
+// NewResolver registers the informers and their IP indexes. Call Start
+// before Lookup.
+func NewResolver(client kubernetes.Interface) (*Resolver, error) {
+	factory := informers.NewSharedInformerFactory(client, defaultResync)
+
+	r := &Resolver{
+		factory:  factory,
+		pods:     factory.Core().V1().Pods().Informer(),
+		services: factory.Core().V1().Services().Informer(),
+		slices:   factory.Discovery().V1().EndpointSlices().Informer(),
+
+		replicaSets: factory.Apps().V1().ReplicaSets().Informer(),
+	}
+
+	if err := r.pods.AddIndexers(cache.Indexers{podIPIndex: podIPs}); err != nil {
+		return nil, fmt.Errorf("add pod ip index: %w", err)
+	}
+	if err := r.services.AddIndexers(cache.Indexers{clusterIPIndex: clusterIPs}); err != nil {
+		return nil, fmt.Errorf("add cluster ip index: %w", err)
+	}
+	if err := r.slices.AddIndexers(cache.Indexers{endpointIPIndex: endpointIPs}); err != nil {
+		return nil, fmt.Errorf("add endpoint ip index: %w", err)
+	}
+	return r, nil
+}
+
+// Start runs the informers until ctx is done and waits for the initial sync.
+func (r *Resolver) Start(ctx context.Context) error {
+	r.factory.Start(ctx.Done())
+	for typ, ok := range r.factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("peer informer %v did not sync", typ)
+		}
+	}
+	return nil
+}
+
+// Lookup resolves ip to the pod owning it and the service selecting that
+// pod, or to the service whose ClusterIP it is.
+func (r *Resolver) Lookup(ip string) (Info, bool) {
+	if ip == "" {
+		return Info{}, false
+	}
+
+	if pod := r.podByIP(ip); pod != nil {
+		return Info{
+			Pod:       pod.Name,
+			Namespace: pod.Namespace,
+			Service:   r.serviceByEndpointIP(ip),
+		}, true
+	}
+
+	if svc := r.serviceByClusterIP(ip); svc != nil {
+		return Info{Namespace: svc.Namespace, Service: svc.Name}, true
+	}
+
+	if name, ns := r.endpointOwner(ip); name != "" {
+		// Endpoints outside the pod network (e.g. selector-less services).
+		return Info{Namespace: ns, Service: name}, true
+	}
+	return Info{}, false
+}
+
+func (r *Resolver) podByIP(ip string) *corev1.Pod {
+	objs, err := r.pods.GetIndexer().ByIndex(podIPIndex, ip)
+	if err != nil {
+		return nil
+	}
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		// Pod IPs are reused; prefer the one still running.
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			return pod
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) serviceByClusterIP(ip string) *corev1.Service {
+	objs, err := r.services.GetIndexer().ByIndex(clusterIPIndex, ip)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+	svc, _ := objs[0].(*corev1.Service)
+	return svc
+}
+
+func (r *Resolver) serviceByEndpointIP(ip string) string {
+	name, _ := r.endpointOwner(ip)
+	return name
+}
+
+func (r *Resolver) endpointOwner(ip string) (string, string) {
+	objs, err := r.slices.GetIndexer().ByIndex(endpointIPIndex, ip)
+	if err != nil {
+		return "", ""
+	}
+	for _, obj := range objs {
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			continue
+		}
+		if name := slice.Labels[discoveryv1.LabelServiceName]; name != "" {
+			return name, slice.Namespace
+		}
+	}
+	return "", ""
+}
+
+// podIPs indexes pods by their IPs. Host-network pods share the node IP
+// and would shadow each other, so they are left out.
+func podIPs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	var ips []string
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips, nil
+}
+
+func clusterIPs(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != "" && ip != corev1.ClusterIPNone {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+func endpointIPs(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, ep := range slice.Endpoints {
+		ips = append(ips, ep.Addresses...)
+	}
+	return ips, nil
+}
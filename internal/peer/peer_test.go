@@ -0,0 +1,145 @@
+package peer
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestResolver(t *testing.T) *Resolver {
+	t.Helper()
+
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-1",
+				Namespace:       "shop",
+				Labels:          map[string]string{"team": "checkout"},
+				OwnerReferences: []metav1.OwnerReference{controllerRef("ReplicaSet", "web-5f7c")},
+			},
+			Status: corev1.PodStatus{
+				Phase:  corev1.PodRunning,
+				PodIPs: []corev1.PodIP{{IP: "10.0.1.7"}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "shop"},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded, PodIP: "10.0.1.8"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "node-agent",
+				Namespace:       "kube-system",
+				OwnerReferences: []metav1.OwnerReference{controllerRef("DaemonSet", "node-agent")},
+			},
+			Spec:   corev1.PodSpec{HostNetwork: true},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "192.168.0.10"},
+		},
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-5f7c",
+				Namespace:       "shop",
+				OwnerReferences: []metav1.OwnerReference{controllerRef("Deployment", "web")},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.20", ClusterIPs: []string{"10.96.0.20"}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-abc",
+				Namespace: "shop",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.0.1.7"}}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db-ext",
+				Namespace: "shop",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "db"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"172.20.0.5"}}},
+		},
+	)
+
+	r, err := NewResolver(client)
+	if err != nil {
+		t.Fatalf("NewResolver returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := r.Start(ctx); err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	return r
+}
+
+func controllerRef(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{Kind: kind, Name: name, Controller: &controller}
+}
+
+func TestResolverLookup(t *testing.T) {
+	r := newTestResolver(t)
+
+	tests := []struct {
+		ip     string
+		want   Info
+		wantOK bool
+	}{
+		{"10.0.1.7", Info{Pod: "web-1", Namespace: "shop", Service: "web"}, true},
+		{"10.96.0.20", Info{Namespace: "shop", Service: "web"}, true},
+		{"172.20.0.5", Info{Namespace: "shop", Service: "db"}, true},
+		{"10.0.1.8", Info{}, false},     // completed pod
+		{"192.168.0.10", Info{}, false}, // host network
+		{"8.8.8.8", Info{}, false},
+		{"", Info{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := r.Lookup(tt.ip)
+		if ok != tt.wantOK || got != tt.want {
+			t.Fatalf("Lookup(%q) = %+v, %v; want %+v, %v", tt.ip, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestResolverWorkload(t *testing.T) {
+	r := newTestResolver(t)
+
+	tests := []struct {
+		namespace, pod string
+		kind, name     string
+		wantOK         bool
+	}{
+		{"shop", "web-1", "Deployment", "web", true},
+		{"kube-system", "node-agent", "DaemonSet", "node-agent", true},
+		{"shop", "old", "", "", true},
+		{"shop", "missing", "", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := r.Workload(tt.namespace, tt.pod)
+		if ok != tt.wantOK {
+			t.Fatalf("Workload(%s/%s) ok = %v, want %v", tt.namespace, tt.pod, ok, tt.wantOK)
+		}
+		if !ok {
+			continue
+		}
+		if got.OwnerKind != tt.kind || got.OwnerName != tt.name {
+			t.Fatalf("Workload(%s/%s) owner = %s/%s, want %s/%s", tt.namespace, tt.pod, got.OwnerKind, got.OwnerName, tt.kind, tt.name)
+		}
+	}
+
+	if w, _ := r.Workload("shop", "web-1"); w.Labels["team"] != "checkout" {
+		t.Fatalf("expected pod labels on workload, got %+v", w.Labels)
+	}
+}
@@ -0,0 +1,52 @@
+package peer
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+// Workload returns the controller owning the pod and its labels and
+// annotations. Pods created by a Deployment report the Deployment rather
+// than the intermediate ReplicaSet.
+func (r *Resolver) Workload(namespace, name string) (*sock.Workload, bool) {
+	obj, ok, err := r.pods.GetIndexer().GetByKey(namespace + "/" + name)
+	if err != nil || !ok {
+		return nil, false
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, false
+	}
+
+	w := &sock.Workload{
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		w.OwnerKind, w.OwnerName = r.resolveOwner(namespace, owner)
+	}
+	return w, true
+}
+
+// resolveOwner follows a ReplicaSet to the Deployment controlling it.
+// Standalone ReplicaSets, and ones not synced yet, are reported as is.
+func (r *Resolver) resolveOwner(namespace string, owner *metav1.OwnerReference) (string, string) {
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind, owner.Name
+	}
+
+	obj, ok, err := r.replicaSets.GetIndexer().GetByKey(namespace + "/" + owner.Name)
+	if err != nil || !ok {
+		return owner.Kind, owner.Name
+	}
+	rs, ok := obj.(metav1.Object)
+	if !ok {
+		return owner.Kind, owner.Name
+	}
+	if parent := metav1.GetControllerOf(rs); parent != nil && parent.Kind == "Deployment" {
+		return parent.Kind, parent.Name
+	}
+	return owner.Kind, owner.Name
+}
@@ -0,0 +1,56 @@
+package proctracker
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/net-lens/flow-lens/internal/common"
+)
+
+// Event is a decoded process event: the common header fields it uses and
+// the payload of struct flow_proc_event in proc_tracker.c.
+type Event struct {
+	Timestamp uint64
+	CgroupID  uint64
+	Netns     uint32
+
+	Type uint32
+	PID  uint32
+	PPID uint32
+
+	Comm [16]byte
+}
+
+// Byte offsets of the payload fields of struct flow_proc_event, after the
+// common header. TestEventLayout checks them against the BTF of
+// proc_tracker.o.
+const (
+	offPPID     = 0
+	offComm     = 4
+	payloadSize = 24
+)
+
+// events decodes the samples of the proc_events map.
+var events = common.NewEventRegistry[Event]()
+
+func init() {
+	for _, typ := range []uint16{TypeFork, TypeExec, TypeExit} {
+		events.Register(common.ModuleProcTracker, typ, decodePayload)
+	}
+}
+
+// decodePayload fills e from a process event without allocating.
+func decodePayload(hdr common.EventHeader, payload []byte, e *Event) error {
+	if len(payload) < payloadSize {
+		return fmt.Errorf("short process event payload: %d bytes, want at least %d", len(payload), payloadSize)
+	}
+
+	e.Timestamp = hdr.Timestamp
+	e.CgroupID = hdr.Cgroup
+	e.Netns = hdr.Netns
+	e.Type = uint32(hdr.Type)
+	e.PID = hdr.PID
+	e.PPID = binary.LittleEndian.Uint32(payload[offPPID:])
+	copy(e.Comm[:], payload[offComm:])
+	return nil
+}
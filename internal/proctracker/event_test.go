@@ -0,0 +1,96 @@
+package proctracker
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/net-lens/flow-lens/internal/common"
+)
+
+const procTrackerObject = "../../bpf/proctracker/proc_tracker.o"
+
+// sample builds a raw process event of typ with the given payload size.
+func sample(typ uint16, size int) []byte {
+	data := make([]byte, common.EventHeaderSize+size)
+	data[0] = common.EventSchemaVersion
+	data[1] = common.EventHeaderSize
+	data[2] = byte(common.ModuleProcTracker)
+	binary.LittleEndian.PutUint16(data[4:], typ)
+	binary.LittleEndian.PutUint16(data[6:], uint16(len(data)))
+	binary.LittleEndian.PutUint64(data[8:], 99)          // timestamp
+	binary.LittleEndian.PutUint64(data[16:], 7)          // cgroup
+	binary.LittleEndian.PutUint32(data[24:], 4026531840) // netns
+	binary.LittleEndian.PutUint32(data[28:], 11)         // pid
+	return data
+}
+
+func TestDecodeEvent(t *testing.T) {
+	data := sample(TypeFork, payloadSize)
+	payload := data[common.EventHeaderSize:]
+	binary.LittleEndian.PutUint32(payload[offPPID:], 10)
+	copy(payload[offComm:], "nginx")
+
+	var evt Event
+	if _, err := events.Decode(data, &evt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := Event{Timestamp: 99, CgroupID: 7, Netns: 4026531840, Type: TypeFork, PID: 11, PPID: 10}
+	copy(want.Comm[:], "nginx")
+	if evt != want {
+		t.Fatalf("got %+v, want %+v", evt, want)
+	}
+}
+
+func TestDecodeEventErrors(t *testing.T) {
+	var evt Event
+	if _, err := events.Decode(sample(TypeExit, payloadSize-1), &evt); err == nil || errors.Is(err, common.ErrUnknownEvent) {
+		t.Fatalf("expected a decode error for a short payload, got %v", err)
+	}
+	if _, err := events.Decode(sample(9, payloadSize), &evt); !errors.Is(err, common.ErrUnknownEvent) {
+		t.Fatalf("expected ErrUnknownEvent, got %v", err)
+	}
+}
+
+// TestEventLayout fails when struct flow_proc_event in proc_tracker.c and the
+// decoder disagree. It needs the object built by make ebpf.
+func TestEventLayout(t *testing.T) {
+	if _, err := os.Stat(procTrackerObject); errors.Is(err, os.ErrNotExist) {
+		t.Skipf("%s not built, run make ebpf", procTrackerObject)
+	}
+
+	spec, err := btf.LoadSpec(procTrackerObject)
+	if err != nil {
+		t.Fatalf("load BTF: %v", err)
+	}
+	var event *btf.Struct
+	if err := spec.TypeByName("flow_proc_event", &event); err != nil {
+		t.Fatalf("find struct flow_proc_event: %v", err)
+	}
+
+	offsets := map[string]int{"ppid": offPPID, "comm": offComm}
+	for _, member := range event.Members {
+		offset := int(member.Offset.Bytes())
+		switch member.Name {
+		case "hdr":
+			if offset != 0 {
+				t.Errorf("struct flow_proc_event.hdr at offset %d, want 0", offset)
+			}
+		case "_pad":
+		default:
+			want, ok := offsets[member.Name]
+			if !ok {
+				t.Fatalf("struct flow_proc_event.%s is not decoded", member.Name)
+			}
+			if got := offset - common.EventHeaderSize; got != want {
+				t.Errorf("struct flow_proc_event.%s is at payload offset %d, decoder reads %d", member.Name, got, want)
+			}
+		}
+	}
+	if got := int(event.Size) - common.EventHeaderSize; got != payloadSize {
+		t.Errorf("struct flow_proc_event payload is %d bytes, payloadSize is %d", got, payloadSize)
+	}
+}
@@ -0,0 +1,187 @@
+package proctracker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+const dropPollInterval = 10 * time.Second
+
+// Event types written by the BPF programs into the event header type.
+const (
+	TypeFork = 1
+	TypeExec = 2
+	TypeExit = 3
+)
+
+// ProcessCache is told about every process lifecycle event.
+type ProcessCache interface {
+	ProcessForked(parent, child int, cgroupID uint64)
+	ProcessExec(pid int, cgroupID uint64)
+	ProcessExited(pid int)
+}
+
+type sockProcesses struct{}
+
+func (sockProcesses) ProcessForked(parent, child int, cgroupID uint64) {
+	sock.ProcessForked(parent, child, cgroupID)
+}
+func (sockProcesses) ProcessExec(pid int, cgroupID uint64) { sock.ProcessExec(pid, cgroupID) }
+func (sockProcesses) ProcessExited(pid int)                { sock.ProcessExited(pid) }
+
+// Manager follows process fork/exec/exit in the kernel and keeps the sock
+// PID cache exact for every process in a container.
+type Manager struct {
+	Collection *ebpf.Collection
+	// Processes receives the events; nil updates the sock PID cache.
+	Processes ProcessCache
+	forkLink  link.Link
+	execLink  link.Link
+	exitLink  link.Link
+
+	// pidCgroup is read by enricher goroutines while the module starts
+	// and stops.
+	pidCgroup atomic.Pointer[ebpf.Map]
+}
+
+// Load opens the BPF object and validates that required programs exist.
+// The ring buffer is required: per-CPU perf buffers would deliver the
+// exit of a PID before its fork.
+func (m *Manager) Load(objFileName string) error {
+	if err := features.HaveMapType(ebpf.RingBuf); err != nil {
+		return fmt.Errorf("process tracking needs BPF ring buffers (Linux 5.8+): %w", err)
+	}
+
+	coll, err := common.LoadObjects(objFileName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{
+		"tp_btf__sched_process_fork",
+		"tp_btf__sched_process_exec",
+		"tp_btf__sched_process_exit",
+	} {
+		if coll.Programs[name] == nil {
+			coll.Close()
+			return fmt.Errorf("missing required program %s in %s", name, objFileName)
+		}
+	}
+	for _, name := range []string{"proc_events", "pid_cgroup"} {
+		if coll.Maps[name] == nil {
+			coll.Close()
+			return fmt.Errorf("missing required map %s in %s", name, objFileName)
+		}
+	}
+
+	m.Collection = coll
+	m.pidCgroup.Store(coll.Maps["pid_cgroup"])
+	return nil
+}
+
+// Attach binds the tracing programs and keeps the links for cleanup.
+func (m *Manager) Attach() error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+
+	forkLink, err := common.AttachTracing(m.Collection.Programs["tp_btf__sched_process_fork"])
+	if err != nil {
+		return err
+	}
+
+	execLink, err := common.AttachTracing(m.Collection.Programs["tp_btf__sched_process_exec"])
+	if err != nil {
+		forkLink.Close()
+		return err
+	}
+
+	exitLink, err := common.AttachTracing(m.Collection.Programs["tp_btf__sched_process_exit"])
+	if err != nil {
+		forkLink.Close()
+		execLink.Close()
+		return err
+	}
+
+	m.forkLink = forkLink
+	m.execLink = execLink
+	m.exitLink = exitLink
+	return nil
+}
+
+func (m *Manager) Run(ctx context.Context) error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+	fmt.Println("Process tracker running")
+
+	handler := events.Handler("proc_events", func(_ common.EventHeader, evt *Event) error {
+		m.handleEvent(*evt)
+		return nil
+	})
+	if drops := m.Collection.Maps["ringbuf_drops"]; drops != nil {
+		go common.PollDropCounter(ctx, drops, "proc_events", dropPollInterval)
+	}
+	// The ring buffer delivers the fork of a PID before its exit; one
+	// worker applies them in that order.
+	return common.PollRingbuf(ctx, m.Collection, "proc_events", common.ReaderOptions{Workers: 1}, handler)
+}
+
+// CgroupID returns the cgroup id the kernel recorded for pid at its last
+// fork or exec, for sock.SetPIDCgroups.
+func (m *Manager) CgroupID(pid int) (uint64, bool) {
+	pidCgroup := m.pidCgroup.Load()
+	if pidCgroup == nil || pid <= 0 {
+		return 0, false
+	}
+	var id uint64
+	if err := pidCgroup.Lookup(uint32(pid), &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func (m *Manager) handleEvent(evt Event) {
+	processes := m.Processes
+	if processes == nil {
+		processes = sockProcesses{}
+	}
+
+	switch evt.Type {
+	case TypeFork:
+		processes.ProcessForked(int(evt.PPID), int(evt.PID), evt.CgroupID)
+	case TypeExec:
+		processes.ProcessExec(int(evt.PID), evt.CgroupID)
+	case TypeExit:
+		processes.ProcessExited(int(evt.PID))
+	}
+}
+
+// Close detaches links and closes the collection.
+func (m *Manager) Close() error {
+	for _, ln := range []*link.Link{&m.forkLink, &m.execLink, &m.exitLink} {
+		if *ln == nil {
+			continue
+		}
+		if err := (*ln).Close(); err != nil {
+			return err
+		}
+		*ln = nil
+	}
+
+	m.pidCgroup.Store(nil)
+	if m.Collection != nil {
+		m.Collection.Close()
+		m.Collection = nil
+	}
+	return nil
+}
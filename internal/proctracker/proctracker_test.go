@@ -0,0 +1,72 @@
+package proctracker
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+type fakeProcesses struct {
+	calls []string
+}
+
+func (f *fakeProcesses) ProcessForked(parent, child int, cgroupID uint64) {
+	f.calls = append(f.calls, fmt.Sprintf("fork %d->%d cgroup %d", parent, child, cgroupID))
+}
+
+func (f *fakeProcesses) ProcessExec(pid int, cgroupID uint64) {
+	f.calls = append(f.calls, fmt.Sprintf("exec %d cgroup %d", pid, cgroupID))
+}
+
+func (f *fakeProcesses) ProcessExited(pid int) {
+	f.calls = append(f.calls, fmt.Sprintf("exit %d", pid))
+}
+
+func TestHandleEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  Event
+		want []string
+	}{
+		{"fork", Event{Type: TypeFork, PID: 11, PPID: 10, CgroupID: 7}, []string{"fork 10->11 cgroup 7"}},
+		{"exec", Event{Type: TypeExec, PID: 11, CgroupID: 7}, []string{"exec 11 cgroup 7"}},
+		{"exit", Event{Type: TypeExit, PID: 11, CgroupID: 7}, []string{"exit 11"}},
+		{"unknown type", Event{Type: 42, PID: 11}, nil},
+	}
+
+	for _, tt := range tests {
+		processes := &fakeProcesses{}
+		m := &Manager{Processes: processes}
+		m.handleEvent(tt.evt)
+		if !reflect.DeepEqual(processes.calls, tt.want) {
+			t.Fatalf("%s: calls = %v, want %v", tt.name, processes.calls, tt.want)
+		}
+	}
+}
+
+// TestCgroupID needs permission to create BPF maps and skips without.
+func TestCgroupID(t *testing.T) {
+	m := &Manager{}
+	if _, ok := m.CgroupID(11); ok {
+		t.Fatalf("expected no cgroup id before Load")
+	}
+
+	pidCgroup, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 4})
+	if err != nil {
+		t.Skipf("create BPF map: %v", err)
+	}
+	defer pidCgroup.Close()
+	if err := pidCgroup.Put(uint32(11), uint64(7)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	m.pidCgroup.Store(pidCgroup)
+
+	if id, ok := m.CgroupID(11); !ok || id != 7 {
+		t.Fatalf("CgroupID(11) = %d, %v, want 7", id, ok)
+	}
+	if _, ok := m.CgroupID(12); ok {
+		t.Fatalf("expected no cgroup id for an unknown pid")
+	}
+}
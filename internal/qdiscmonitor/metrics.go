@@ -0,0 +1,69 @@
+package qdiscmonitor
+
+import (
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/sock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var qdiscLabels = []string{
+	"interface",
+	"target_pod",
+	"target_namespace",
+}
+
+var (
+	QdiscDrops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "qdisc",
+			Name:      "drops_total",
+			Help:      "Packets dropped by a qdisc on enqueue (shaping/queue overflow) labeled by device",
+		},
+		qdiscLabels,
+	)
+
+	QdiscRequeues = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "qdisc",
+			Name:      "requeues_total",
+			Help:      "Packets requeued because the device driver was busy labeled by device",
+		},
+		qdiscLabels,
+	)
+)
+
+func labelOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func recordQdisc(kind int, ifName string, info sock.ContainerInfo, delta uint64) {
+	if delta == 0 {
+		return
+	}
+
+	var counter *prometheus.CounterVec
+	switch kind {
+	case KindDrop:
+		counter = QdiscDrops
+	case KindRequeue:
+		counter = QdiscRequeues
+	default:
+		return
+	}
+
+	counter.WithLabelValues(
+		labelOrUnknown(ifName),
+		labelOrUnknown(info.PodName),
+		labelOrUnknown(info.Namespace),
+	).Add(float64(delta))
+}
+
+func init() {
+	common.RegisterMetric(QdiscDrops)
+	common.RegisterMetric(QdiscRequeues)
+}
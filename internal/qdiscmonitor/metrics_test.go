@@ -0,0 +1,44 @@
+package qdiscmonitor
+
+import (
+	"testing"
+
+	"github.com/net-lens/flow-lens/internal/sock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		prev, cur, want uint64
+	}{
+		{0, 5, 5},
+		{5, 12, 7},
+		{12, 3, 3}, // entry recreated
+	}
+
+	for _, tt := range tests {
+		if got := counterDelta(tt.prev, tt.cur); got != tt.want {
+			t.Fatalf("counterDelta(%d, %d) = %d, want %d", tt.prev, tt.cur, got, tt.want)
+		}
+	}
+}
+
+func TestRecordQdisc(t *testing.T) {
+	QdiscDrops.Reset()
+	QdiscRequeues.Reset()
+
+	info := sock.ContainerInfo{Namespace: "ns", PodName: "pod"}
+	recordQdisc(KindDrop, "veth1", info, 3)
+	recordQdisc(KindRequeue, "eth0", sock.ContainerInfo{}, 2)
+	recordQdisc(99, "eth0", sock.ContainerInfo{}, 1)
+
+	if got := testutil.ToFloat64(QdiscDrops.WithLabelValues("veth1", "pod", "ns")); got != 3 {
+		t.Fatalf("expected 3 drops, got %v", got)
+	}
+	if got := testutil.ToFloat64(QdiscRequeues.WithLabelValues("eth0", "unknown", "unknown")); got != 2 {
+		t.Fatalf("expected 2 requeues, got %v", got)
+	}
+	if got := testutil.CollectAndCount(QdiscDrops); got != 1 {
+		t.Fatalf("expected unknown kinds to be ignored, got %d drop series", got)
+	}
+}
@@ -0,0 +1,225 @@
+package qdiscmonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/enrich"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+const pollInterval = 10 * time.Second
+
+// Event kinds written by the BPF programs into qdisc_key.kind.
+const (
+	KindDrop    = 1
+	KindRequeue = 2
+)
+
+// Manager counts qdisc enqueue drops and driver requeues per device and
+// attributes them to pods through their host veth.
+type Manager struct {
+	Collection *ebpf.Collection
+	// Enrichers attribute interfaces to pods through their ifindex; nil
+	// leaves pod labels unknown.
+	Enrichers *enrich.Chain
+
+	tpKfreeSkbLink link.Link
+	tpDevXmitLink  link.Link
+
+	hostNetns uint32
+	last      map[qdiscKey]uint64
+}
+
+// qdiscKey mirrors struct qdisc_key in qdisc_monitor.c.
+type qdiscKey struct {
+	Ifindex uint32
+	Kind    uint32
+	Netns   uint32
+}
+
+// Load opens the BPF object and validates that required programs exist.
+func (m *Manager) Load(objFileName string) error {
+	spec, err := common.LoadSpec(objFileName)
+	if err != nil {
+		return err
+	}
+
+	// Only host devices have names net.Interfaces can resolve.
+	hostNetns, err := sock.HostNetns()
+	if err != nil {
+		return fmt.Errorf("host network namespace: %w", err)
+	}
+	if err := spec.RewriteConstants(map[string]interface{}{"host_netns": uint32(hostNetns)}); err != nil {
+		return fmt.Errorf("configure host network namespace: %w", err)
+	}
+
+	coll, err := common.NewCollection(spec)
+	if err != nil {
+		return err
+	}
+
+	if coll.Programs["tracepoint__skb__kfree_skb"] == nil ||
+		coll.Programs["tracepoint__net__net_dev_xmit"] == nil ||
+		coll.Maps["qdisc_stats"] == nil {
+		coll.Close()
+		return fmt.Errorf("missing required qdisc programs or maps in %s", objFileName)
+	}
+
+	m.Collection = coll
+	m.hostNetns = uint32(hostNetns)
+	m.last = map[qdiscKey]uint64{}
+	return nil
+}
+
+// Attach binds the tracepoint programs and keeps the links for cleanup.
+func (m *Manager) Attach() error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+
+	tpKfreeSkb, err := common.AttachTracepoint("skb", "kfree_skb", m.Collection.Programs["tracepoint__skb__kfree_skb"])
+	if err != nil {
+		return err
+	}
+
+	tpDevXmit, err := common.AttachTracepoint("net", "net_dev_xmit", m.Collection.Programs["tracepoint__net__net_dev_xmit"])
+	if err != nil {
+		tpKfreeSkb.Close()
+		return err
+	}
+
+	m.tpKfreeSkbLink = tpKfreeSkb
+	m.tpDevXmitLink = tpDevXmit
+	return nil
+}
+
+// Run periodically drains the per-cpu counters into Prometheus.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+	fmt.Println("Qdisc monitor running")
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := m.collect(ctx); err != nil {
+				log.Printf("[qdiscmonitor] collect: %v", err)
+			}
+		}
+	}
+}
+
+// Close detaches links and closes the collection.
+func (m *Manager) Close() error {
+	if m.tpKfreeSkbLink != nil {
+		if err := m.tpKfreeSkbLink.Close(); err != nil {
+			return err
+		}
+		m.tpKfreeSkbLink = nil
+	}
+
+	if m.tpDevXmitLink != nil {
+		if err := m.tpDevXmitLink.Close(); err != nil {
+			return err
+		}
+		m.tpDevXmitLink = nil
+	}
+
+	if m.Collection != nil {
+		m.Collection.Close()
+		m.Collection = nil
+	}
+	return nil
+}
+
+func (m *Manager) collect(ctx context.Context) error {
+	stats := m.Collection.Maps["qdisc_stats"]
+
+	var (
+		key     qdiscKey
+		perCPU  []uint64
+		current = make(map[qdiscKey]uint64)
+		stale   []qdiscKey
+	)
+
+	// Names are read on every collect: an ifindex may be reused by a new
+	// device between two reads.
+	names, err := interfaceNames()
+	if err != nil {
+		return err
+	}
+
+	iter := stats.Iterate()
+	for iter.Next(&key, &perCPU) {
+		name, ok := names[key.Ifindex]
+		if !ok || key.Netns != m.hostNetns {
+			stale = append(stale, key)
+			continue
+		}
+
+		var total uint64
+		for _, v := range perCPU {
+			total += v
+		}
+		current[key] = total
+
+		recordQdisc(int(key.Kind), name, m.pod(ctx, key.Ifindex), counterDelta(m.last[key], total))
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate qdisc stats: %w", err)
+	}
+	m.last = current
+
+	for _, k := range stale {
+		if err := stats.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			log.Printf("[qdiscmonitor] delete stale key %+v: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// pod returns the pod behind a host interface, empty when unknown.
+func (m *Manager) pod(ctx context.Context, ifindex uint32) sock.ContainerInfo {
+	evt := enrich.Event{Ifindex: int(ifindex)}
+	if m.Enrichers != nil {
+		m.Enrichers.Enrich(ctx, &evt)
+	}
+	return evt.Source
+}
+
+// interfaceNames maps the ifindex of every current device to its name.
+func interfaceNames() (map[uint32]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	names := make(map[uint32]string, len(ifaces))
+	for _, iface := range ifaces {
+		names[uint32(iface.Index)] = iface.Name
+	}
+	return names, nil
+}
+
+// counterDelta returns the increase since the previous read. A smaller
+// value means the map entry was recreated, so the whole value is new.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
@@ -0,0 +1,72 @@
+package qdiscmonitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/net-lens/flow-lens/internal/enrich"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+// TestCollectCountsHostDevicesOnly needs permission to create BPF maps and
+// skips without.
+func TestCollectCountsHostDevicesOnly(t *testing.T) {
+	stats, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.PerCPUHash,
+		KeySize:    uint32(binary.Size(qdiscKey{})),
+		ValueSize:  8,
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Skipf("create BPF map: %v", err)
+	}
+	defer stats.Close()
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		t.Fatalf("possible CPUs: %v", err)
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback device: %v", err)
+	}
+
+	const host, pod = 4026531840, 4026532001
+	put := func(k qdiscKey, n uint64) {
+		perCPU := make([]uint64, cpus)
+		perCPU[0] = n
+		if err := stats.Put(k, perCPU); err != nil {
+			t.Fatalf("put %+v: %v", k, err)
+		}
+	}
+	hostKey := qdiscKey{Ifindex: uint32(lo.Index), Kind: KindDrop, Netns: host}
+	podKey := qdiscKey{Ifindex: uint32(lo.Index), Kind: KindDrop, Netns: pod}
+	put(hostKey, 3)
+	put(podKey, 5)
+
+	QdiscDrops.Reset()
+	m := &Manager{
+		Collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"qdisc_stats": stats}},
+		Enrichers: enrich.NewChain(&enrich.InterfaceEnricher{Lookup: func(ifindex int) (sock.ContainerInfo, bool) {
+			return sock.ContainerInfo{PodName: "web-0", Namespace: "shop"}, ifindex == lo.Index
+		}}),
+		hostNetns: host,
+		last:      map[qdiscKey]uint64{},
+	}
+	if err := m.collect(context.Background()); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	if got := testutil.ToFloat64(QdiscDrops.WithLabelValues(lo.Name, "web-0", "shop")); got != 3 {
+		t.Fatalf("expected the host device's 3 drops, got %v", got)
+	}
+	var perCPU []uint64
+	if err := stats.Lookup(podKey, &perCPU); !errors.Is(err, ebpf.ErrKeyNotExist) {
+		t.Fatalf("expected the pod namespace entry to be deleted, got %v", err)
+	}
+}
@@ -5,9 +5,21 @@ import (
 )
 
 type ContainerInfo struct {
-	Namespace     string
-	PodName       string
-	ContainerName string
+	Namespace     string `json:"namespace,omitempty"`
+	PodName       string `json:"pod,omitempty"`
+	ContainerName string `json:"container,omitempty"`
+	ContainerID   string `json:"container_id,omitempty"`
+	Image         string `json:"image,omitempty"`
+	PodUID        string `json:"pod_uid,omitempty"`
+	PodIP         string `json:"pod_ip,omitempty"`
+	HostNetwork   bool   `json:"host_network,omitempty"`
+	// Process, Executable and Unit describe processes outside containers
+	// when HOST_PROCESS_LABELS is enabled.
+	Process    string `json:"process,omitempty"`
+	Executable string `json:"executable,omitempty"`
+	Unit       string `json:"unit,omitempty"`
+	// Workload is attached on lookup when a WorkloadSource is configured.
+	Workload *Workload `json:"workload,omitempty"`
 }
 
 type pidCache struct {
@@ -39,3 +51,21 @@ func (c *pidCache) Get(pid int) (ContainerInfo, bool) {
 	ci, ok := c.pidMap[pid]
 	return ci, ok
 }
+
+// Len returns the number of cached PIDs.
+func (c *pidCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.pidMap)
+}
+
+// Snapshot returns a copy of the pid → container mapping.
+func (c *pidCache) Snapshot() map[int]ContainerInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[int]ContainerInfo, len(c.pidMap))
+	for k, v := range c.pidMap {
+		out[k] = v
+	}
+	return out
+}
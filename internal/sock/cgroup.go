@@ -0,0 +1,377 @@
+package sock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hostProcessTTL = 30 * time.Second
+	maxCgroupIDs   = 65536
+)
+
+var (
+	errHostProcess      = errors.New("process does not run in a container")
+	errContainerUnknown = errors.New("container not known to the runtime watcher yet")
+)
+
+// cgroupResolver attributes arbitrary PIDs (workers, forked children, exec
+// sessions) to containers by reading /proc/<pid>/cgroup. Resolved PIDs are
+// written to the PID cache and evicted with their container; PIDs outside
+// any container are remembered for hostProcessTTL.
+type cgroupResolver struct {
+	procRoot string
+	pids     *pidCache
+	ttl      time.Duration
+	now      func() time.Time
+	// lookup describes containers the runtime has not reported (yet).
+	lookup func(id string) (ContainerInfo, bool)
+	// describeHost fills comm, executable and systemd unit for PIDs
+	// outside any container.
+	describeHost atomic.Bool
+	// maxCgroupIDs bounds cgroupIDs; the map starts over once it is full.
+	maxCgroupIDs int
+
+	mu         sync.Mutex
+	containers map[string]ContainerInfo    // container id → info
+	resolved   map[string]map[int]struct{} // container id → pids cached via cgroup
+	owners     map[int]string              // pid → container id, reverse of resolved
+	host       map[int]hostEntry           // pid → negative entry
+	cgroupIDs  map[uint64]string           // kernel cgroup id → container id, "" for host cgroups
+}
+
+func newCgroupResolver(procRoot string, pids *pidCache) *cgroupResolver {
+	return &cgroupResolver{
+		procRoot:     procRoot,
+		pids:         pids,
+		ttl:          hostProcessTTL,
+		now:          time.Now,
+		maxCgroupIDs: maxCgroupIDs,
+		containers:   make(map[string]ContainerInfo),
+		resolved:     make(map[string]map[int]struct{}),
+		owners:       make(map[int]string),
+		host:         make(map[int]hostEntry),
+		cgroupIDs:    make(map[uint64]string),
+	}
+}
+
+// SetContainer registers a running container and its init PID.
+func (r *cgroupResolver) SetContainer(id string, pid int, info ContainerInfo) {
+	r.mu.Lock()
+	r.containers[id] = info
+	r.rememberLocked(id, pid, info)
+	r.mu.Unlock()
+}
+
+// SetLookup installs a fallback for container IDs no runtime reported.
+// Those answers are not cached, as no runtime event would evict them.
+func (r *cgroupResolver) SetLookup(lookup func(id string) (ContainerInfo, bool)) {
+	r.mu.Lock()
+	r.lookup = lookup
+	r.mu.Unlock()
+}
+
+// PruneContainers deletes every container not in live and returns the
+// PIDs that were evicted with them.
+func (r *cgroupResolver) PruneContainers(live map[string]struct{}) []int {
+	r.mu.Lock()
+	var stale []string
+	for id := range r.containers {
+		if _, ok := live[id]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	r.mu.Unlock()
+
+	var evicted []int
+	for _, id := range stale {
+		evicted = append(evicted, r.DeleteContainer(id)...)
+	}
+	return evicted
+}
+
+// DeleteContainer forgets the container and every PID resolved into it,
+// and returns those PIDs.
+func (r *cgroupResolver) DeleteContainer(id string) []int {
+	r.mu.Lock()
+	pids := r.resolved[id]
+	delete(r.resolved, id)
+	delete(r.containers, id)
+	for pid := range pids {
+		delete(r.owners, pid)
+	}
+	for cgroupID, owner := range r.cgroupIDs {
+		if owner == id {
+			delete(r.cgroupIDs, cgroupID)
+		}
+	}
+	r.mu.Unlock()
+
+	evicted := make([]int, 0, len(pids))
+	for pid := range pids {
+		r.pids.Delete(pid)
+		evicted = append(evicted, pid)
+	}
+	return evicted
+}
+
+// Resolve maps pid to its container through the cgroup hierarchy.
+func (r *cgroupResolver) Resolve(pid int) (ContainerInfo, error) {
+	info, _, err := r.resolve(pid)
+	return info, err
+}
+
+// ResolveCgroup is Resolve for callers that also know the kernel cgroup id
+// of the process. Known cgroup ids are answered without touching /proc.
+func (r *cgroupResolver) ResolveCgroup(pid int, cgroupID uint64) (ContainerInfo, error) {
+	r.mu.Lock()
+	if id, ok := r.cgroupIDs[cgroupID]; ok {
+		defer r.mu.Unlock()
+		if id == "" {
+			return ContainerInfo{}, errHostProcess
+		}
+		info, ok := r.containers[id]
+		if !ok {
+			if info, ok := r.lookupLocked(id); ok {
+				return info, nil
+			}
+			return ContainerInfo{}, errContainerUnknown
+		}
+		r.rememberLocked(id, pid, info)
+		return info, nil
+	}
+	r.mu.Unlock()
+
+	info, id, err := r.resolve(pid)
+	if err == nil || errors.Is(err, errHostProcess) {
+		r.mu.Lock()
+		if len(r.cgroupIDs) >= r.maxCgroupIDs {
+			r.cgroupIDs = make(map[uint64]string)
+		}
+		r.cgroupIDs[cgroupID] = id
+		r.mu.Unlock()
+	}
+	return info, err
+}
+
+// adoptPID ties child to the container its parent was resolved into, so
+// it is evicted together with it.
+func (r *cgroupResolver) adoptPID(parent, child int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id, ok := r.owners[parent]; ok {
+		r.resolved[id][child] = struct{}{}
+		r.owners[child] = id
+	}
+}
+
+// ForgetPID drops every trace of an exited process.
+func (r *cgroupResolver) ForgetPID(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.host, pid)
+	if id, ok := r.owners[pid]; ok {
+		delete(r.owners, pid)
+		delete(r.resolved[id], pid)
+	}
+}
+
+// Peek is Resolve without side effects: nothing is cached, remembered or
+// expired, so inspecting a PID does not change how events are attributed.
+func (r *cgroupResolver) Peek(pid int) (ContainerInfo, error) {
+	r.mu.Lock()
+	entry, ok := r.host[pid]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.info, errHostProcess
+	}
+
+	id, unit, err := r.readCgroup(pid)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	if id == "" {
+		var hostInfo ContainerInfo
+		if r.describeHost.Load() {
+			hostInfo = describeHostProcess(r.procRoot, pid, unit)
+		}
+		return hostInfo, errHostProcess
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.containers[id]; ok {
+		return info, nil
+	}
+	if info, ok := r.lookupLocked(id); ok {
+		return info, nil
+	}
+	return ContainerInfo{}, errContainerUnknown
+}
+
+func (r *cgroupResolver) resolve(pid int) (ContainerInfo, string, error) {
+	now := r.now()
+
+	r.mu.Lock()
+	if entry, ok := r.host[pid]; ok {
+		if now.Before(entry.expires) {
+			r.mu.Unlock()
+			return entry.info, "", errHostProcess
+		}
+		delete(r.host, pid)
+	}
+	r.mu.Unlock()
+
+	id, unit, err := r.readCgroup(pid)
+	if err != nil {
+		return ContainerInfo{}, "", err
+	}
+
+	var hostInfo ContainerInfo
+	if id == "" && r.describeHost.Load() {
+		hostInfo = describeHostProcess(r.procRoot, pid, unit)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == "" {
+		r.host[pid] = hostEntry{expires: now.Add(r.ttl), info: hostInfo}
+		r.pruneHostLocked(now)
+		return hostInfo, "", errHostProcess
+	}
+
+	info, ok := r.containers[id]
+	if !ok {
+		if info, ok := r.lookupLocked(id); ok {
+			return info, id, nil
+		}
+		return ContainerInfo{}, id, errContainerUnknown
+	}
+
+	r.rememberLocked(id, pid, info)
+	return info, id, nil
+}
+
+func (r *cgroupResolver) lookupLocked(id string) (ContainerInfo, bool) {
+	if r.lookup == nil {
+		return ContainerInfo{}, false
+	}
+	return r.lookup(id)
+}
+
+func (r *cgroupResolver) rememberLocked(id string, pid int, info ContainerInfo) {
+	if r.resolved[id] == nil {
+		r.resolved[id] = make(map[int]struct{})
+	}
+	r.resolved[id][pid] = struct{}{}
+	r.owners[pid] = id
+	r.pids.Set(pid, info)
+}
+
+// cgroupSnapshot is the resolver state exposed by the debug endpoint.
+type cgroupSnapshot struct {
+	Containers map[string]ContainerInfo `json:"containers"`
+	CgroupIDs  map[uint64]string        `json:"cgroup_ids"`
+	HostPIDs   int                      `json:"host_pids"`
+}
+
+// Snapshot copies the known containers and cgroup ids.
+func (r *cgroupResolver) Snapshot() cgroupSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := cgroupSnapshot{
+		Containers: make(map[string]ContainerInfo, len(r.containers)),
+		CgroupIDs:  make(map[uint64]string, len(r.cgroupIDs)),
+		HostPIDs:   len(r.host),
+	}
+	for k, v := range r.containers {
+		out.Containers[k] = v
+	}
+	for k, v := range r.cgroupIDs {
+		out.CgroupIDs[k] = v
+	}
+	return out
+}
+
+// readCgroup returns the container ID of pid, or "" and the systemd unit
+// (if any) for processes outside containers.
+func (r *cgroupResolver) readCgroup(pid int) (string, string, error) {
+	f, err := os.Open(filepath.Join(r.procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", "", fmt.Errorf("read cgroup of pid %d: %w", pid, err)
+	}
+	defer f.Close()
+
+	var unit string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if id := containerIDFromCgroupLine(line); id != "" {
+			return id, "", nil
+		}
+		if u := systemdUnitFromCgroupLine(line); u != "" {
+			unit = u
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("read cgroup of pid %d: %w", pid, err)
+	}
+	return "", unit, nil
+}
+
+func (r *cgroupResolver) pruneHostLocked(now time.Time) {
+	if len(r.host) < 4096 {
+		return
+	}
+	for pid, entry := range r.host {
+		if !now.Before(entry.expires) {
+			delete(r.host, pid)
+		}
+	}
+}
+
+// containerIDFromCgroupLine extracts a container ID from one line of
+// /proc/<pid>/cgroup ("hierarchy:controllers:path"). It understands
+// cgroup v1 and v2 with both the cgroupfs driver
+// (/kubepods/burstable/pod<uid>/<id>) and the systemd driver
+// (/kubepods.slice/.../cri-containerd-<id>.scope, crio-<id>.scope,
+// docker-<id>.scope).
+func containerIDFromCgroupLine(line string) string {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+
+	segments := strings.Split(parts[2], "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		seg := strings.TrimSuffix(segments[i], ".scope")
+		if idx := strings.LastIndexByte(seg, '-'); idx >= 0 {
+			seg = seg[idx+1:]
+		}
+		if isContainerID(seg) {
+			return seg
+		}
+	}
+	return ""
+}
+
+func isContainerID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
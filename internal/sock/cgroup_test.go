@@ -0,0 +1,151 @@
+package sock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestContainerIDFromCgroupLine(t *testing.T) {
+	tests := []struct {
+		name, line, want string
+	}{
+		{"v2 systemd containerd", "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1a2b.slice/cri-containerd-" + testContainerID + ".scope", testContainerID},
+		{"v2 systemd crio", "0::/kubepods.slice/kubepods-pod1a2b.slice/crio-" + testContainerID + ".scope", testContainerID},
+		{"v1 cgroupfs", "4:memory:/kubepods/besteffort/pod1a2b/" + testContainerID, testContainerID},
+		{"v1 docker systemd", "1:name=systemd:/system.slice/docker-" + testContainerID + ".scope", testContainerID},
+		{"cgroupns relative", "0::/../../kubepods-pod1a2b.slice/cri-containerd-" + testContainerID + ".scope", testContainerID},
+		{"host systemd unit", "0::/system.slice/sshd.service", ""},
+		{"host root", "0::/", ""},
+		{"malformed", "garbage", ""},
+	}
+
+	for _, tt := range tests {
+		if got := containerIDFromCgroupLine(tt.line); got != tt.want {
+			t.Fatalf("%s: containerIDFromCgroupLine = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func writeProcCgroup(t *testing.T, root string, pid int, content string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write cgroup: %v", err)
+	}
+}
+
+func TestCgroupResolverResolve(t *testing.T) {
+	root := t.TempDir()
+	pids := newPIDCache()
+	r := newCgroupResolver(root, pids)
+
+	info := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerName: "ctr"}
+	r.SetContainer(testContainerID, 1, info)
+
+	writeProcCgroup(t, root, 200, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+	writeProcCgroup(t, root, 300, "0::/kubepods.slice/cri-containerd-"+
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff.scope\n")
+
+	got, err := r.Resolve(200)
+	if err != nil || got != info {
+		t.Fatalf("expected %+v, got %+v (err=%v)", info, got, err)
+	}
+	if cached, ok := pids.Get(200); !ok || cached != info {
+		t.Fatalf("expected resolved pid to be cached, got %+v (ok=%v)", cached, ok)
+	}
+
+	if _, err := r.Resolve(300); !errors.Is(err, errContainerUnknown) {
+		t.Fatalf("expected errContainerUnknown, got %v", err)
+	}
+
+	if _, err := r.Resolve(999); err == nil {
+		t.Fatalf("expected error for missing /proc entry")
+	}
+
+	r.DeleteContainer(testContainerID)
+	if _, ok := pids.Get(200); ok {
+		t.Fatalf("expected pid to be evicted with its container")
+	}
+}
+
+func TestCgroupResolverNegativeCache(t *testing.T) {
+	root := t.TempDir()
+	r := newCgroupResolver(root, newPIDCache())
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	r.SetContainer(testContainerID, 1, ContainerInfo{PodName: "pod"})
+	writeProcCgroup(t, root, 400, "0::/system.slice/sshd.service\n")
+
+	if _, err := r.Resolve(400); !errors.Is(err, errHostProcess) {
+		t.Fatalf("expected errHostProcess, got %v", err)
+	}
+
+	// The PID is reused by a container process: still negative until the TTL.
+	writeProcCgroup(t, root, 400, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+	if _, err := r.Resolve(400); !errors.Is(err, errHostProcess) {
+		t.Fatalf("expected negative cache hit, got %v", err)
+	}
+
+	now = now.Add(hostProcessTTL)
+	if info, err := r.Resolve(400); err != nil || info.PodName != "pod" {
+		t.Fatalf("expected re-resolution after TTL, got %+v (err=%v)", info, err)
+	}
+}
+
+func TestCgroupResolverLookupFallback(t *testing.T) {
+	root := t.TempDir()
+	pids := newPIDCache()
+	r := newCgroupResolver(root, pids)
+
+	info := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerID: testContainerID}
+	r.SetLookup(fakeMetadata{testContainerID: info}.ContainerInfo)
+
+	writeProcCgroup(t, root, 200, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+
+	if got, err := r.ResolveCgroup(200, 42); err != nil || got != info {
+		t.Fatalf("expected %+v from lookup, got %+v (err=%v)", info, got, err)
+	}
+	if got, err := r.ResolveCgroup(201, 42); err != nil || got != info {
+		t.Fatalf("expected known cgroup id to use lookup, got %+v (err=%v)", got, err)
+	}
+	if _, ok := pids.Get(200); ok {
+		t.Fatalf("lookup answers must not be cached")
+	}
+}
+
+func TestCgroupIDsStartOverWhenFull(t *testing.T) {
+	root := t.TempDir()
+	r := newCgroupResolver(root, newPIDCache())
+	r.maxCgroupIDs = 2
+
+	info := ContainerInfo{PodName: "pod"}
+	r.SetContainer(testContainerID, 1, info)
+	for pid := 100; pid < 103; pid++ {
+		writeProcCgroup(t, root, pid, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+		if _, err := r.ResolveCgroup(pid, uint64(pid)); err != nil {
+			t.Fatalf("ResolveCgroup(%d): %v", pid, err)
+		}
+	}
+
+	if len(r.cgroupIDs) != 1 {
+		t.Fatalf("expected the full map to start over, got %d entries", len(r.cgroupIDs))
+	}
+	if _, ok := r.cgroupIDs[102]; !ok {
+		t.Fatalf("expected the newest cgroup id to be kept, got %v", r.cgroupIDs)
+	}
+
+	// Forgotten ids are resolved through /proc again.
+	if got, err := r.ResolveCgroup(100, 100); err != nil || got != info {
+		t.Fatalf("expected %+v after the reset, got %+v (err=%v)", info, got, err)
+	}
+}
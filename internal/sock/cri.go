@@ -0,0 +1,215 @@
+package sock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	criPollInterval = 5 * time.Second
+	criCallTimeout  = 5 * time.Second
+)
+
+type podMeta struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// criRuntime keeps the PID cache in sync through the CRI RuntimeService,
+// which every Kubernetes runtime (containerd, CRI-O, ...) serves. CRI has
+// no portable event stream, so running containers are polled and diffed.
+type criRuntime struct {
+	conn     *grpc.ClientConn
+	client   runtimeapi.RuntimeServiceClient
+	cache    *pidCache
+	interval time.Duration
+
+	known     map[string]int // container id → pid
+	sandboxes map[string]podMeta
+}
+
+func dialCRI(socket string, cache *pidCache) (*criRuntime, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connect to CRI at %s: %w", socket, err)
+	}
+	return newCRIRuntime(conn, runtimeapi.NewRuntimeServiceClient(conn), cache), nil
+}
+
+func newCRIRuntime(conn *grpc.ClientConn, client runtimeapi.RuntimeServiceClient, cache *pidCache) *criRuntime {
+	return &criRuntime{
+		conn:      conn,
+		client:    client,
+		cache:     cache,
+		interval:  criPollInterval,
+		known:     make(map[string]int),
+		sandboxes: make(map[string]podMeta),
+	}
+}
+
+func (r *criRuntime) run(ctx context.Context) {
+	defer r.Close()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.sync(ctx); err != nil {
+				log.Printf("[cri watcher] sync: %v", err)
+			}
+		}
+	}
+}
+
+// sync adds containers that started since the last call and forgets the
+// ones that are gone.
+func (r *criRuntime) sync(ctx context.Context) error {
+	callCtx, cancel := context.WithTimeout(ctx, criCallTimeout)
+	defer cancel()
+
+	resp, err := r.client.ListContainers(callCtx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{
+			State: &runtimeapi.ContainerStateValue{State: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("list containers: %w", err)
+	}
+
+	running := make(map[string]struct{}, len(resp.Containers))
+	liveSandboxes := make(map[string]struct{})
+
+	for _, c := range resp.Containers {
+		running[c.Id] = struct{}{}
+		liveSandboxes[c.PodSandboxId] = struct{}{}
+		if _, ok := r.known[c.Id]; ok {
+			continue
+		}
+
+		pid, info, err := r.containerInfo(ctx, c)
+		if err != nil {
+			log.Printf("[cri watcher] container %s: %v", c.Id, err)
+			continue
+		}
+		r.known[c.Id] = pid
+		r.cache.Set(pid, info)
+		cgroups.SetContainer(c.Id, pid, info)
+		trackInterface(pid, info)
+	}
+
+	for id, pid := range r.known {
+		if _, ok := running[id]; ok {
+			continue
+		}
+		r.cache.Delete(pid)
+		ifaces.Untrack(pid)
+		cgroups.DeleteContainer(id)
+		delete(r.known, id)
+	}
+	for id := range r.sandboxes {
+		if _, ok := liveSandboxes[id]; !ok {
+			delete(r.sandboxes, id)
+		}
+	}
+	return nil
+}
+
+func (r *criRuntime) containerInfo(ctx context.Context, c *runtimeapi.Container) (int, ContainerInfo, error) {
+	callCtx, cancel := context.WithTimeout(ctx, criCallTimeout)
+	defer cancel()
+
+	status, err := r.client.ContainerStatus(callCtx, &runtimeapi.ContainerStatusRequest{
+		ContainerId: c.Id,
+		Verbose:     true,
+	})
+	if err != nil {
+		return 0, ContainerInfo{}, fmt.Errorf("container status: %w", err)
+	}
+
+	pid, err := verbosePID(status.Info)
+	if err != nil {
+		return 0, ContainerInfo{}, err
+	}
+
+	pod, err := r.podMeta(ctx, c.PodSandboxId)
+	if err != nil {
+		return 0, ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: c.Labels["io.kubernetes.container.name"],
+		ContainerID:   c.Id,
+		Image:         c.GetImage().GetImage(),
+		PodUID:        pod.UID,
+	}
+	if info.ContainerName == "" && c.Metadata != nil {
+		info.ContainerName = c.Metadata.Name
+	}
+	return pid, info, nil
+}
+
+func (r *criRuntime) podMeta(ctx context.Context, sandboxID string) (podMeta, error) {
+	if meta, ok := r.sandboxes[sandboxID]; ok {
+		return meta, nil
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, criCallTimeout)
+	defer cancel()
+
+	resp, err := r.client.PodSandboxStatus(callCtx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: sandboxID})
+	if err != nil {
+		return podMeta{}, fmt.Errorf("pod sandbox status %s: %w", sandboxID, err)
+	}
+	if resp.Status == nil || resp.Status.Metadata == nil {
+		return podMeta{}, fmt.Errorf("pod sandbox %s has no metadata", sandboxID)
+	}
+
+	meta := podMeta{
+		Namespace: resp.Status.Metadata.Namespace,
+		Name:      resp.Status.Metadata.Name,
+		UID:       resp.Status.Metadata.Uid,
+	}
+	r.sandboxes[sandboxID] = meta
+	return meta, nil
+}
+
+// Close releases the gRPC connection.
+func (r *criRuntime) Close() error {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// verbosePID extracts the container's init PID from the verbose "info"
+// blob; containerd and CRI-O both report it as a top-level "pid" field.
+func verbosePID(info map[string]string) (int, error) {
+	raw, ok := info["info"]
+	if !ok {
+		return 0, fmt.Errorf("verbose status carries no info")
+	}
+	var v struct {
+		Pid int `json:"pid"`
+	}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return 0, fmt.Errorf("decode verbose info: %w", err)
+	}
+	if v.Pid <= 0 {
+		return 0, fmt.Errorf("verbose info has no pid")
+	}
+	return v.Pid, nil
+}
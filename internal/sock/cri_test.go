@@ -0,0 +1,157 @@
+package sock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeCRI serves the subset of RuntimeService flow-lens uses.
+type fakeCRI struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+
+	mu         sync.Mutex
+	containers map[string]*runtimeapi.Container
+	pids       map[string]int
+	sandboxes  map[string]*runtimeapi.PodSandboxMetadata
+}
+
+func (f *fakeCRI) ListContainers(_ context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &runtimeapi.ListContainersResponse{}
+	for _, c := range f.containers {
+		if st := req.GetFilter().GetState(); st != nil && st.State != c.State {
+			continue
+		}
+		resp.Containers = append(resp.Containers, c)
+	}
+	return resp, nil
+}
+
+func (f *fakeCRI) ContainerStatus(_ context.Context, req *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pid, ok := f.pids[req.ContainerId]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", req.ContainerId)
+	}
+	return &runtimeapi.ContainerStatusResponse{
+		Status: &runtimeapi.ContainerStatus{Id: req.ContainerId},
+		Info:   map[string]string{"info": fmt.Sprintf(`{"pid": %d, "sandboxID": "x"}`, pid)},
+	}, nil
+}
+
+func (f *fakeCRI) PodSandboxStatus(_ context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	meta, ok := f.sandboxes[req.PodSandboxId]
+	if !ok {
+		return nil, fmt.Errorf("sandbox %s not found", req.PodSandboxId)
+	}
+	return &runtimeapi.PodSandboxStatusResponse{
+		Status: &runtimeapi.PodSandboxStatus{Id: req.PodSandboxId, Metadata: meta},
+	}, nil
+}
+
+func (f *fakeCRI) remove(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.containers, id)
+}
+
+func startFakeCRI(t *testing.T, f *fakeCRI) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "cri.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	srv := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return socket
+}
+
+func TestCRIRuntimeSync(t *testing.T) {
+	fake := &fakeCRI{
+		containers: map[string]*runtimeapi.Container{
+			"c1": {
+				Id:           "c1",
+				PodSandboxId: "s1",
+				State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+				Metadata:     &runtimeapi.ContainerMetadata{Name: "app"},
+				Labels:       map[string]string{"io.kubernetes.container.name": "app"},
+			},
+			"c2": {
+				Id:           "c2",
+				PodSandboxId: "s1",
+				State:        runtimeapi.ContainerState_CONTAINER_EXITED,
+			},
+		},
+		pids:      map[string]int{"c1": 4242, "c2": 4343},
+		sandboxes: map[string]*runtimeapi.PodSandboxMetadata{"s1": {Name: "web-1", Namespace: "shop", Uid: "7d1c5e"}},
+	}
+	socket := startFakeCRI(t, fake)
+
+	// Interface tracking needs real netns; keep it out of the unit test.
+	originalIfaces := ifaces
+	ifaces = newInterfaceIndex(func(int) (int, error) { return 0, errHostNetwork })
+	t.Cleanup(func() { ifaces = originalIfaces })
+
+	pids := newPIDCache()
+	rt, err := dialCRI(socket, pids)
+	if err != nil {
+		t.Fatalf("dialCRI returned error: %v", err)
+	}
+	t.Cleanup(func() { rt.Close() })
+
+	if err := rt.sync(context.Background()); err != nil {
+		t.Fatalf("sync returned error: %v", err)
+	}
+
+	want := ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app", ContainerID: "c1", PodUID: "7d1c5e"}
+	if got, ok := pids.Get(4242); !ok || got != want {
+		t.Fatalf("expected %+v for pid 4242, got %+v (ok=%v)", want, got, ok)
+	}
+	if _, ok := pids.Get(4343); ok {
+		t.Fatalf("exited container should not be cached")
+	}
+
+	fake.remove("c1")
+	if err := rt.sync(context.Background()); err != nil {
+		t.Fatalf("sync returned error: %v", err)
+	}
+	if _, ok := pids.Get(4242); ok {
+		t.Fatalf("expected pid 4242 to be evicted after container removal")
+	}
+}
+
+func TestVerbosePID(t *testing.T) {
+	tests := []struct {
+		info    map[string]string
+		want    int
+		wantErr bool
+	}{
+		{map[string]string{"info": `{"pid": 17}`}, 17, false},
+		{map[string]string{"info": `{"pid": 0}`}, 0, true},
+		{map[string]string{"info": `not json`}, 0, true},
+		{map[string]string{}, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := verbosePID(tt.info)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("verbosePID(%v) = %d, %v; want %d, err=%v", tt.info, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package sock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	lastEvent   atomic.Int64 // unix seconds of the last containerd event
+)
+
+type debugStats struct {
+	PIDCacheSize int        `json:"pid_cache_size"`
+	CacheHits    uint64     `json:"cache_hits"`
+	CacheMisses  uint64     `json:"cache_misses"`
+	Interfaces   int        `json:"interfaces"`
+	LastEvent    *time.Time `json:"last_containerd_event,omitempty"`
+}
+
+type debugDump struct {
+	Stats      debugStats            `json:"stats"`
+	PIDs       map[int]ContainerInfo `json:"pids"`
+	Interfaces map[int]ContainerInfo `json:"interfaces"`
+	Cgroups    cgroupSnapshot        `json:"cgroups"`
+	Netns      netnsSnapshot         `json:"netns"`
+}
+
+type debugPID struct {
+	PID      int            `json:"pid"`
+	Netns    uint64         `json:"netns,omitempty"`
+	Cached   *ContainerInfo `json:"cached,omitempty"`
+	Resolved *ContainerInfo `json:"resolved,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// DebugHandler serves what the agent believes about PIDs, containers and
+// interfaces as JSON. Without parameters it dumps every index, including
+// the netns index as last built; ?pid=N
+// resolves a single PID and ?netns=INODE lists the cached PIDs living in
+// a network namespace.
+func DebugHandler() http.Handler {
+	return debugHandler{procRoot: "/proc"}
+}
+
+type debugHandler struct {
+	procRoot string
+}
+
+func (h debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	switch {
+	case q.Has("pid"):
+		pid, err := strconv.Atoi(q.Get("pid"))
+		if err != nil || pid <= 0 {
+			http.Error(w, "invalid pid", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, h.lookupPID(pid))
+
+	case q.Has("netns"):
+		ino, err := strconv.ParseUint(q.Get("netns"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid netns inode", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, h.lookupNetns(ino))
+
+	default:
+		writeJSON(w, debugDump{
+			Stats:      stats(),
+			PIDs:       cache.Snapshot(),
+			Interfaces: ifaces.Snapshot(),
+			Cgroups:    cgroups.Snapshot(),
+			Netns:      netnsPods.Snapshot(),
+		})
+	}
+}
+
+func (h debugHandler) lookupPID(pid int) debugPID {
+	out := debugPID{PID: pid}
+	out.Netns, _ = h.netnsInode(pid)
+	if info, ok := cache.Get(pid); ok {
+		out.Cached = &info
+	}
+
+	// Same path as event attribution, minus the hit/miss accounting and
+	// without caching anything: a debug read must not change attribution.
+	var (
+		info ContainerInfo
+		err  error
+	)
+	if out.Cached != nil {
+		info = withWorkload(describe(*out.Cached))
+	} else {
+		info, err = cgroups.Peek(pid)
+		if err == nil {
+			info = withWorkload(describe(info))
+		} else if errors.Is(err, errHostProcess) {
+			err = nil
+		}
+	}
+	if err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Resolved = &info
+	}
+	return out
+}
+
+// lookupNetns scans the cached PIDs, which is fine for a debug request.
+func (h debugHandler) lookupNetns(ino uint64) []debugPID {
+	out := []debugPID{}
+	for pid, info := range cache.Snapshot() {
+		if got, err := h.netnsInode(pid); err != nil || got != ino {
+			continue
+		}
+		info := info
+		out = append(out, debugPID{PID: pid, Netns: ino, Cached: &info})
+	}
+	return out
+}
+
+func (h debugHandler) netnsInode(pid int) (uint64, error) {
+	return netnsInode(h.procRoot, pid)
+}
+
+// netnsInode returns the inode of the network namespace of pid.
+func netnsInode(procRoot string, pid int) (uint64, error) {
+	fi, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.New("no inode for netns")
+	}
+	return st.Ino, nil
+}
+
+func stats() debugStats {
+	s := debugStats{
+		PIDCacheSize: cache.Len(),
+		CacheHits:    cacheHits.Load(),
+		CacheMisses:  cacheMisses.Load(),
+		Interfaces:   len(ifaces.Snapshot()),
+	}
+	if ts := lastEvent.Load(); ts > 0 {
+		t := time.Unix(ts, 0).UTC()
+		s.LastEvent = &t
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
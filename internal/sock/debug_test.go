@@ -0,0 +1,112 @@
+package sock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func serveDebug(t *testing.T, h http.Handler, query string, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/attribution"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", query, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("GET %s: decode: %v", query, err)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	root := t.TempDir()
+	originalCache, originalCgroups, originalNetns := cache, cgroups, netnsPods
+	cache = newPIDCache()
+	cgroups = newCgroupResolver(t.TempDir(), cache)
+	netnsPods = newNetnsIndex(root, cache)
+	t.Cleanup(func() { cache, cgroups, netnsPods = originalCache, originalCgroups, originalNetns })
+
+	for _, pid := range []int{10, 11} {
+		dir := filepath.Join(root, strconv.Itoa(pid), "ns")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "net"), nil, 0o644); err != nil {
+			t.Fatalf("write netns: %v", err)
+		}
+	}
+	h := debugHandler{procRoot: root}
+
+	web := ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app"}
+	cache.Set(10, web)
+	cache.Set(11, ContainerInfo{Namespace: "shop", PodName: "db-0", ContainerName: "db"})
+
+	var one debugPID
+	serveDebug(t, h, "?pid=10", &one)
+	if one.Cached == nil || *one.Cached != web || one.Resolved == nil || one.Netns == 0 {
+		t.Fatalf("unexpected pid lookup: %+v", one)
+	}
+
+	NetnsPod(one.Netns) // builds the index
+	var dump debugDump
+	serveDebug(t, h, "", &dump)
+	if dump.Stats.PIDCacheSize != 2 || dump.PIDs[10] != web {
+		t.Fatalf("unexpected dump: %+v", dump)
+	}
+	if pod := dump.Netns.Pods[one.Netns]; pod.PodName != "web-1" || dump.Netns.Refreshed == nil {
+		t.Fatalf("expected the netns index in the dump, got %+v", dump.Netns)
+	}
+
+	var inNetns []debugPID
+	serveDebug(t, h, "?netns="+strconv.FormatUint(one.Netns, 10), &inNetns)
+	if len(inNetns) != 1 || inNetns[0].PID != 10 {
+		t.Fatalf("expected only pid 10 in netns %d, got %+v", one.Netns, inNetns)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/attribution?pid=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid pid, got %d", rec.Code)
+	}
+}
+
+func TestDebugHandlerLeavesCachesAlone(t *testing.T) {
+	root := t.TempDir()
+	originalCache, originalCgroups := cache, cgroups
+	cache = newPIDCache()
+	cgroups = newCgroupResolver(root, cache)
+	t.Cleanup(func() { cache, cgroups = originalCache, originalCgroups })
+
+	web := ContainerInfo{Namespace: "shop", PodName: "web-1"}
+	cgroups.SetContainer(testContainerID, 1, web)
+	cache.Delete(1)
+	writeProcCgroup(t, root, 20, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+	writeProcCgroup(t, root, 30, "0::/system.slice/sshd.service\n")
+	h := debugHandler{procRoot: root}
+
+	var ctr debugPID
+	serveDebug(t, h, "?pid=20", &ctr)
+	if ctr.Resolved == nil || ctr.Resolved.PodName != "web-1" {
+		t.Fatalf("expected pid 20 to resolve to web-1, got %+v", ctr)
+	}
+	var host debugPID
+	serveDebug(t, h, "?pid=30", &host)
+	if host.Resolved == nil || host.Error != "" {
+		t.Fatalf("expected pid 30 to resolve as a host process, got %+v", host)
+	}
+
+	if _, ok := cache.Get(20); ok {
+		t.Fatalf("debug lookup cached pid 20")
+	}
+	snap := cgroups.Snapshot()
+	if snap.HostPIDs != 0 {
+		t.Fatalf("debug lookup added %d host entries", snap.HostPIDs)
+	}
+	if _, ok := cgroups.owners[20]; ok {
+		t.Fatalf("debug lookup tied pid 20 to its container")
+	}
+}
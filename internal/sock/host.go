@@ -0,0 +1,62 @@
+package sock
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hostEntry remembers a PID outside any container until expires.
+type hostEntry struct {
+	expires time.Time
+	info    ContainerInfo
+}
+
+// SetHostProcessLabels makes attribution describe processes outside
+// containers by comm, executable and systemd unit. Call it before
+// InitRuntime.
+func SetHostProcessLabels(enabled bool) {
+	cgroups.withHostProcesses(enabled)
+}
+
+// withHostProcesses enables describing host processes.
+func (r *cgroupResolver) withHostProcesses(enabled bool) *cgroupResolver {
+	r.describeHost.Store(enabled)
+	return r
+}
+
+// describeHostProcess reads the comm and executable of a process outside
+// any container. Kernel threads have no executable and keep it empty.
+func describeHostProcess(procRoot string, pid int, unit string) ContainerInfo {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	info := ContainerInfo{Unit: unit}
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		info.Process = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		info.Executable = strings.TrimSuffix(exe, " (deleted)")
+	}
+	return info
+}
+
+// systemdUnitFromCgroupLine returns the innermost systemd service or scope
+// of a cgroup v2 line, or of the v1 name=systemd hierarchy, e.g.
+// "sshd.service" for "0::/system.slice/sshd.service".
+func systemdUnitFromCgroupLine(line string) string {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 || (parts[1] != "" && parts[1] != "name=systemd") {
+		return ""
+	}
+
+	segments := strings.Split(parts[2], "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		seg := segments[i]
+		if strings.HasSuffix(seg, ".service") || strings.HasSuffix(seg, ".scope") {
+			return seg
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package sock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSystemdUnitFromCgroupLine(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"0::/system.slice/sshd.service", "sshd.service"},
+		{"0::/user.slice/user-1000.slice/session-3.scope", "session-3.scope"},
+		{"0::/system.slice/nginx.service/worker", "nginx.service"},
+		{"1:name=systemd:/system.slice/chronyd.service", "chronyd.service"},
+		{"4:memory:/system.slice/sshd.service", ""},
+		{"0::/", ""},
+		{"garbage", ""},
+	}
+
+	for _, tt := range tests {
+		if got := systemdUnitFromCgroupLine(tt.line); got != tt.want {
+			t.Fatalf("systemdUnitFromCgroupLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestCgroupResolverDescribesHostProcesses(t *testing.T) {
+	root := t.TempDir()
+	r := newCgroupResolver(root, newPIDCache()).withHostProcesses(true)
+
+	writeProcCgroup(t, root, 500, "0::/system.slice/sshd.service\n")
+	dir := filepath.Join(root, "500")
+	if err := os.WriteFile(filepath.Join(dir, "comm"), []byte("sshd\n"), 0o644); err != nil {
+		t.Fatalf("write comm: %v", err)
+	}
+	if err := os.Symlink("/usr/sbin/sshd", filepath.Join(dir, "exe")); err != nil {
+		t.Fatalf("symlink exe: %v", err)
+	}
+
+	want := ContainerInfo{Process: "sshd", Executable: "/usr/sbin/sshd", Unit: "sshd.service"}
+	for i := 0; i < 2; i++ { // second call is served from the negative cache
+		info, err := r.Resolve(500)
+		if !errors.Is(err, errHostProcess) || info != want {
+			t.Fatalf("expected %+v with errHostProcess, got %+v (err=%v)", want, info, err)
+		}
+	}
+
+	plain := newCgroupResolver(root, newPIDCache())
+	if info, _ := plain.Resolve(500); info != (ContainerInfo{}) {
+		t.Fatalf("expected no host description when disabled, got %+v", info)
+	}
+}
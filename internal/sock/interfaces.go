@@ -0,0 +1,145 @@
+package sock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// podInterfaceName is the interface CNI plugins create inside the pod netns.
+const podInterfaceName = "eth0"
+
+var errHostNetwork = errors.New("process shares the host network namespace")
+
+// InterfaceIndex maps host-side veth ifindexes to the pod behind them.
+// Entries are reference counted by task PID because every container of a
+// pod shares the sandbox netns and therefore the same veth.
+type InterfaceIndex struct {
+	mu      sync.RWMutex
+	byIndex map[int]ContainerInfo
+	pids    map[int]int // pid → ifindex
+	refs    map[int]int // ifindex → number of tracked pids
+
+	resolve func(pid int) (int, error)
+}
+
+func newInterfaceIndex(resolve func(pid int) (int, error)) *InterfaceIndex {
+	return &InterfaceIndex{
+		byIndex: make(map[int]ContainerInfo),
+		pids:    make(map[int]int),
+		refs:    make(map[int]int),
+		resolve: resolve,
+	}
+}
+
+// Interfaces returns the process-wide interface index fed by the
+// containerd watcher.
+func Interfaces() *InterfaceIndex {
+	return ifaces
+}
+
+// LookupIfindex returns the pod owning the host interface, if known.
+func (x *InterfaceIndex) LookupIfindex(ifindex int) (ContainerInfo, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	info, ok := x.byIndex[ifindex]
+	return info, ok
+}
+
+// Snapshot returns a copy of the ifindex → pod mapping.
+func (x *InterfaceIndex) Snapshot() map[int]ContainerInfo {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	out := make(map[int]ContainerInfo, len(x.byIndex))
+	for k, v := range x.byIndex {
+		out[k] = v
+	}
+	return out
+}
+
+// Track resolves the host veth of the task's netns and records it. Tasks
+// running in the host network namespace are ignored.
+func (x *InterfaceIndex) Track(pid int, info ContainerInfo) error {
+	ifindex, err := x.resolve(pid)
+	if errors.Is(err, errHostNetwork) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The interface belongs to the pod, not to a single container.
+	info.ContainerName = ""
+	info.ContainerID = ""
+	info.Image = ""
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, ok := x.pids[pid]; ok {
+		x.releaseLocked(old)
+	}
+	x.pids[pid] = ifindex
+	x.refs[ifindex]++
+	x.byIndex[ifindex] = info
+	return nil
+}
+
+// Untrack drops the PID and forgets the interface once no task uses it.
+func (x *InterfaceIndex) Untrack(pid int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ifindex, ok := x.pids[pid]
+	if !ok {
+		return
+	}
+	delete(x.pids, pid)
+	x.releaseLocked(ifindex)
+}
+
+func (x *InterfaceIndex) releaseLocked(ifindex int) {
+	x.refs[ifindex]--
+	if x.refs[ifindex] <= 0 {
+		delete(x.refs, ifindex)
+		delete(x.byIndex, ifindex)
+	}
+}
+
+// resolvePeerIfindex opens the netns of pid and returns the host-side
+// ifindex of its eth0 veth peer.
+func resolvePeerIfindex(pid int) (int, error) {
+	podNS, err := netns.GetFromPid(pid)
+	if err != nil {
+		return 0, fmt.Errorf("open netns of pid %d: %w", pid, err)
+	}
+	defer podNS.Close()
+
+	hostNS, err := netns.Get()
+	if err != nil {
+		return 0, fmt.Errorf("open host netns: %w", err)
+	}
+	defer hostNS.Close()
+
+	if podNS.Equal(hostNS) {
+		return 0, errHostNetwork
+	}
+
+	handle, err := netlink.NewHandleAt(podNS)
+	if err != nil {
+		return 0, fmt.Errorf("netlink handle for pid %d: %w", pid, err)
+	}
+	defer handle.Close()
+
+	ln, err := handle.LinkByName(podInterfaceName)
+	if err != nil {
+		return 0, fmt.Errorf("find %s of pid %d: %w", podInterfaceName, pid, err)
+	}
+
+	peer := ln.Attrs().ParentIndex
+	if peer == 0 {
+		return 0, fmt.Errorf("%s of pid %d (%s) has no peer interface", podInterfaceName, pid, ln.Type())
+	}
+	return peer, nil
+}
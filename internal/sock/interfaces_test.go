@@ -0,0 +1,58 @@
+package sock
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInterfaceIndexTrackSharedNetns(t *testing.T) {
+	peers := map[int]int{100: 7, 101: 7}
+	idx := newInterfaceIndex(func(pid int) (int, error) { return peers[pid], nil })
+
+	pause := ContainerInfo{Namespace: "ns", PodName: "pod"}
+	app := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerName: "app"}
+
+	if err := idx.Track(100, pause); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+	if err := idx.Track(101, app); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+
+	got, ok := idx.LookupIfindex(7)
+	if !ok || got != pause {
+		t.Fatalf("expected pod-level info %+v, got %+v (ok=%v)", pause, got, ok)
+	}
+
+	idx.Untrack(101)
+	if _, ok := idx.LookupIfindex(7); !ok {
+		t.Fatalf("interface dropped while the sandbox is still running")
+	}
+
+	idx.Untrack(100)
+	if _, ok := idx.LookupIfindex(7); ok {
+		t.Fatalf("expected interface to be forgotten after last task exit")
+	}
+}
+
+func TestInterfaceIndexTrackErrors(t *testing.T) {
+	idx := newInterfaceIndex(func(pid int) (int, error) {
+		if pid == 1 {
+			return 0, errHostNetwork
+		}
+		return 0, errors.New("no eth0")
+	})
+
+	if err := idx.Track(1, ContainerInfo{PodName: "host"}); err != nil {
+		t.Fatalf("host network tasks should be ignored, got %v", err)
+	}
+	if err := idx.Track(2, ContainerInfo{PodName: "broken"}); err == nil {
+		t.Fatalf("expected resolver error to be returned")
+	}
+	if got := len(idx.Snapshot()); got != 0 {
+		t.Fatalf("expected empty index, got %d entries", got)
+	}
+
+	// Untracking unknown pids is a no-op.
+	idx.Untrack(2)
+}
@@ -0,0 +1,116 @@
+package sock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	containerIDIndex = "containerID"
+	kubeResync       = 10 * time.Minute
+)
+
+// kubeMetadata describes containers from the Pods scheduled on this node,
+// joined by the container IDs the kubelet reports in the pod status.
+type kubeMetadata struct {
+	factory informers.SharedInformerFactory
+	pods    k8scache.SharedIndexInformer
+}
+
+func newKubeMetadata(client kubernetes.Interface, nodeName string) (*kubeMetadata, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, kubeResync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}),
+	)
+
+	k := &kubeMetadata{
+		factory: factory,
+		pods:    factory.Core().V1().Pods().Informer(),
+	}
+	if err := k.pods.AddIndexers(k8scache.Indexers{containerIDIndex: podContainerIDs}); err != nil {
+		return nil, fmt.Errorf("add container id index: %w", err)
+	}
+	return k, nil
+}
+
+// Start runs the informer until ctx is done and waits for the initial sync.
+func (k *kubeMetadata) Start(ctx context.Context) error {
+	k.factory.Start(ctx.Done())
+	for typ, ok := range k.factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("pod informer %v did not sync", typ)
+		}
+	}
+	return nil
+}
+
+// ContainerInfo returns the pod and container behind a runtime container
+// ID. Containers the kubelet has not reported yet are not found.
+func (k *kubeMetadata) ContainerInfo(containerID string) (ContainerInfo, bool) {
+	objs, err := k.pods.GetIndexer().ByIndex(containerIDIndex, containerID)
+	if err != nil || len(objs) == 0 {
+		return ContainerInfo{}, false
+	}
+	pod, ok := objs[0].(*corev1.Pod)
+	if !ok {
+		return ContainerInfo{}, false
+	}
+
+	info := ContainerInfo{
+		Namespace:   pod.Namespace,
+		PodName:     pod.Name,
+		ContainerID: containerID,
+		PodUID:      string(pod.UID),
+		PodIP:       pod.Status.PodIP,
+		HostNetwork: pod.Spec.HostNetwork,
+	}
+	for _, status := range containerStatuses(pod) {
+		if trimRuntimeScheme(status.ContainerID) == containerID {
+			info.ContainerName = status.Name
+			info.Image = status.Image
+			break
+		}
+	}
+	return info, true
+}
+
+// podContainerIDs indexes pods by the runtime IDs of all their containers.
+func podContainerIDs(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	var ids []string
+	for _, status := range containerStatuses(pod) {
+		if id := trimRuntimeScheme(status.ContainerID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func containerStatuses(pod *corev1.Pod) []corev1.ContainerStatus {
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses)+len(pod.Status.EphemeralContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	return append(statuses, pod.Status.EphemeralContainerStatuses...)
+}
+
+// trimRuntimeScheme strips the "containerd://" or "cri-o://" prefix the
+// kubelet puts in front of container IDs.
+func trimRuntimeScheme(id string) string {
+	if i := strings.Index(id, "://"); i >= 0 {
+		return id[i+3:]
+	}
+	return id
+}
@@ -0,0 +1,130 @@
+package sock
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubeMetadataContainerInfo(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "shop", UID: "7d1c5e"},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status: corev1.PodStatus{
+				PodIP: "10.0.1.7",
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "migrate", ContainerID: "containerd://init1"},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "app", Image: "shop/web:1.2", ContainerID: "containerd://" + testContainerID},
+					{Name: "pending"},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-agent", Namespace: "kube-system"},
+			Spec:       corev1.PodSpec{NodeName: "node-a", HostNetwork: true},
+			Status: corev1.PodStatus{
+				PodIP:             "192.168.0.10",
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "agent", ContainerID: "cri-o://agent1"}},
+			},
+		},
+	)
+
+	k, err := newKubeMetadata(client, "node-a")
+	if err != nil {
+		t.Fatalf("newKubeMetadata returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := k.Start(ctx); err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	tests := []struct {
+		id     string
+		want   ContainerInfo
+		wantOK bool
+	}{
+		{testContainerID, ContainerInfo{
+			Namespace: "shop", PodName: "web-1", ContainerName: "app", ContainerID: testContainerID,
+			Image: "shop/web:1.2", PodUID: "7d1c5e", PodIP: "10.0.1.7",
+		}, true},
+		{"init1", ContainerInfo{
+			Namespace: "shop", PodName: "web-1", ContainerName: "migrate", ContainerID: "init1",
+			PodUID: "7d1c5e", PodIP: "10.0.1.7",
+		}, true},
+		{"agent1", ContainerInfo{
+			Namespace: "kube-system", PodName: "node-agent", ContainerName: "agent", ContainerID: "agent1",
+			PodIP: "192.168.0.10", HostNetwork: true,
+		}, true},
+		{"missing", ContainerInfo{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := k.ContainerInfo(tt.id)
+		if ok != tt.wantOK || got != tt.want {
+			t.Fatalf("ContainerInfo(%q) = %+v, %v; want %+v, %v", tt.id, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+type fakeMetadata map[string]ContainerInfo
+
+func (f fakeMetadata) ContainerInfo(id string) (ContainerInfo, bool) {
+	info, ok := f[id]
+	return info, ok
+}
+
+func TestDescribeProviders(t *testing.T) {
+	originalCgroups := cgroups
+	cgroups = newCgroupResolver(t.TempDir(), newPIDCache())
+	t.Cleanup(func() {
+		setMetadataProviders(true, nil)
+		cgroups = originalCgroups
+	})
+
+	runtime := ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app", ContainerID: "c1", Image: "web"}
+	api := fakeMetadata{"c1": {Namespace: "shop", PodName: "web-1", ContainerID: "c1", PodIP: "10.0.1.7"}}
+
+	setMetadataProviders(true, nil)
+	if got := describe(runtime); got != runtime {
+		t.Fatalf("runtime only: got %+v, want %+v", got, runtime)
+	}
+
+	setMetadataProviders(true, api)
+	want := runtime
+	want.PodIP = "10.0.1.7"
+	if got := describe(runtime); got != want {
+		t.Fatalf("runtime,kubernetes: got %+v, want %+v", got, want)
+	}
+
+	setMetadataProviders(false, api)
+	if got := describe(runtime); got != api["c1"] {
+		t.Fatalf("kubernetes only: got %+v, want %+v", got, api["c1"])
+	}
+}
+
+func TestParseMetadataProviders(t *testing.T) {
+	tests := []struct {
+		in                string
+		runtime, api, err bool
+	}{
+		{"runtime", true, false, false},
+		{"runtime, kubernetes", true, true, false},
+		{"kubernetes", false, true, false},
+		{"", false, false, true},
+		{"docker", false, false, true},
+	}
+
+	for _, tt := range tests {
+		runtime, api, err := parseMetadataProviders(tt.in)
+		if runtime != tt.runtime || api != tt.api || (err != nil) != tt.err {
+			t.Fatalf("parseMetadataProviders(%q) = %v, %v, %v", tt.in, runtime, api, err)
+		}
+	}
+}
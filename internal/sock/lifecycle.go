@@ -0,0 +1,44 @@
+package sock
+
+import (
+	"errors"
+	"log"
+)
+
+// ProcessForked records a new process. The child inherits the parent's
+// container; when the parent is unknown the kernel cgroup id is resolved.
+func ProcessForked(parent, child int, cgroupID uint64) {
+	if info, ok := cache.Get(parent); ok {
+		cache.Set(child, info)
+		cgroups.adoptPID(parent, child)
+		return
+	}
+	resolveLifecycle(child, cgroupID)
+}
+
+// ProcessExec refreshes a process that replaced its image. Exec keeps the
+// cgroup, so this only matters for processes that were unknown so far, and
+// for host processes whose comm and executable just changed.
+func ProcessExec(pid int, cgroupID uint64) {
+	if _, ok := cache.Get(pid); ok {
+		return
+	}
+	cgroups.ForgetPID(pid)
+	resolveLifecycle(pid, cgroupID)
+}
+
+// ProcessExited evicts the PID before the kernel can hand it out again.
+func ProcessExited(pid int) {
+	cache.Delete(pid)
+	cgroups.ForgetPID(pid)
+	ifaces.Untrack(pid)
+}
+
+func resolveLifecycle(pid int, cgroupID uint64) {
+	_, err := cgroups.ResolveCgroup(pid, cgroupID)
+	if err == nil || errors.Is(err, errHostProcess) || errors.Is(err, errContainerUnknown) {
+		return
+	}
+	// Short-lived processes are often gone before /proc can be read.
+	log.Printf("[sock] resolve process %d (cgroup %d): %v", pid, cgroupID, err)
+}
@@ -0,0 +1,67 @@
+package sock
+
+import (
+	"testing"
+)
+
+func withLifecycleState(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+
+	originalCache, originalCgroups, originalIfaces := cache, cgroups, ifaces
+	cache = newPIDCache()
+	cgroups = newCgroupResolver(root, cache)
+	ifaces = newInterfaceIndex(func(int) (int, error) { return 0, errHostNetwork })
+	t.Cleanup(func() { cache, cgroups, ifaces = originalCache, originalCgroups, originalIfaces })
+	return root
+}
+
+func TestProcessForkInheritsAndExitEvicts(t *testing.T) {
+	withLifecycleState(t)
+
+	info := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerName: "ctr"}
+	cache.Set(10, info)
+	cgroups.SetContainer(testContainerID, 10, info)
+
+	ProcessForked(10, 11, 1234)
+	ProcessForked(11, 12, 1234)
+
+	if got, ok := cache.Get(12); !ok || got != info {
+		t.Fatalf("expected grandchild to inherit %+v, got %+v (ok=%v)", info, got, ok)
+	}
+
+	ProcessExited(11)
+	if _, ok := cache.Get(11); ok {
+		t.Fatalf("expected exited pid to be evicted")
+	}
+
+	cgroups.DeleteContainer(testContainerID)
+	if _, ok := cache.Get(12); ok {
+		t.Fatalf("expected forked pid to be evicted with its container")
+	}
+}
+
+func TestProcessExecResolvesByCgroupID(t *testing.T) {
+	root := withLifecycleState(t)
+
+	info := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerName: "ctr"}
+	cgroups.SetContainer(testContainerID, 1, info)
+
+	writeProcCgroup(t, root, 20, "0::/kubepods.slice/cri-containerd-"+testContainerID+".scope\n")
+	ProcessExec(20, 777)
+	if got, ok := cache.Get(20); !ok || got != info {
+		t.Fatalf("expected exec'd pid to be resolved, got %+v (ok=%v)", got, ok)
+	}
+
+	// No /proc entry for pid 21: the cgroup id learned from pid 20 suffices.
+	ProcessExec(21, 777)
+	if got, ok := cache.Get(21); !ok || got != info {
+		t.Fatalf("expected cgroup id hit for pid 21, got %+v (ok=%v)", got, ok)
+	}
+
+	writeProcCgroup(t, root, 30, "0::/system.slice/cron.service\n")
+	ProcessExec(30, 888)
+	if _, ok := cache.Get(30); ok {
+		t.Fatalf("host process must not be cached as a container")
+	}
+}
@@ -0,0 +1,190 @@
+package sock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// The attribution steps, run separately by the enrichment pipeline and
+// together by Sock.
+
+// CachedPID returns what the runtime reported for pid.
+func CachedPID(pid int) (ContainerInfo, bool) {
+	if info, ok := cache.Get(pid); ok {
+		cacheHits.Add(1)
+		return info, true
+	}
+	cacheMisses.Add(1)
+	return ContainerInfo{}, false
+}
+
+// CgroupPID attributes pid through its cgroup, for workers and forked
+// children the runtime did not report. Host processes are found too,
+// described by comm and unit after SetHostProcessLabels(true). A non-zero
+// cgroupID, as recorded by the kernel, skips /proc for known cgroups; when
+// it is 0 the source set with SetPIDCgroups is asked for one.
+func CgroupPID(pid int, cgroupID uint64) (ContainerInfo, bool) {
+	if pid <= 0 {
+		return ContainerInfo{}, false
+	}
+	if cgroupID == 0 {
+		if src := loadPIDCgroups(); src != nil {
+			cgroupID, _ = src.CgroupID(pid)
+		}
+	}
+	var (
+		info ContainerInfo
+		err  error
+	)
+	if cgroupID != 0 {
+		info, err = cgroups.ResolveCgroup(pid, cgroupID)
+	} else {
+		info, err = cgroups.Resolve(pid)
+	}
+	if err == nil || errors.Is(err, errHostProcess) {
+		return info, true
+	}
+	return ContainerInfo{}, false
+}
+
+// PIDCgroups knows the kernel cgroup id of live processes.
+type PIDCgroups interface {
+	CgroupID(pid int) (uint64, bool)
+}
+
+var (
+	pidCgroupsMu sync.RWMutex
+	pidCgroups   PIDCgroups
+)
+
+// SetPIDCgroups makes CgroupPID take cgroup ids from src, such as the
+// in-kernel map of the proctracker module. A nil source disables it.
+func SetPIDCgroups(src PIDCgroups) {
+	pidCgroupsMu.Lock()
+	pidCgroups = src
+	pidCgroupsMu.Unlock()
+}
+
+func loadPIDCgroups() PIDCgroups {
+	pidCgroupsMu.RLock()
+	defer pidCgroupsMu.RUnlock()
+	return pidCgroups
+}
+
+// NetnsPod returns the pod whose network namespace has inode ino, for
+// events without a usable PID. Only pod-level fields are set.
+func NetnsPod(ino uint64) (ContainerInfo, bool) {
+	return netnsPods.Lookup(ino)
+}
+
+// HostNetns returns the inode of the host network namespace, that of
+// init.
+func HostNetns() (uint64, error) {
+	return netnsInode("/proc", 1)
+}
+
+// Describe applies the metadata providers (METADATA_PROVIDERS) and the
+// workload source to info.
+func Describe(info ContainerInfo) ContainerInfo {
+	if info.ContainerID != "" {
+		info = describe(info)
+	}
+	return withWorkload(info)
+}
+
+const (
+	// netnsRefreshMiss is how often a miss may trigger a rescan, so pods
+	// started since the last one are found.
+	netnsRefreshMiss = 5 * time.Second
+	// netnsRefreshMax bounds the age of the index, since inodes of
+	// deleted namespaces can be reused.
+	netnsRefreshMax = 30 * time.Second
+)
+
+var netnsPods = newNetnsIndex("/proc", cache)
+
+// netnsIndex maps network namespace inodes to pods by scanning the
+// /proc/<pid>/ns/net links of the cached PIDs.
+type netnsIndex struct {
+	procRoot string
+	pids     *pidCache
+	now      func() time.Time
+
+	mu        sync.Mutex
+	pods      map[uint64]ContainerInfo
+	refreshed time.Time
+}
+
+func newNetnsIndex(procRoot string, pids *pidCache) *netnsIndex {
+	return &netnsIndex{procRoot: procRoot, pids: pids, now: time.Now}
+}
+
+func (x *netnsIndex) Lookup(ino uint64) (ContainerInfo, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	age := x.now().Sub(x.refreshed)
+	info, ok := x.pods[ino]
+	if (ok && age < netnsRefreshMax) || (!ok && age < netnsRefreshMiss) {
+		return info, ok
+	}
+	x.rebuildLocked()
+	info, ok = x.pods[ino]
+	return info, ok
+}
+
+// netnsSnapshot is the netns index exposed by the debug endpoint.
+type netnsSnapshot struct {
+	Pods      map[uint64]ContainerInfo `json:"pods"`
+	Refreshed *time.Time               `json:"refreshed,omitempty"`
+}
+
+// Snapshot copies the index as last built, without rescanning.
+func (x *netnsIndex) Snapshot() netnsSnapshot {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	out := netnsSnapshot{Pods: make(map[uint64]ContainerInfo, len(x.pods))}
+	for k, v := range x.pods {
+		out.Pods[k] = v
+	}
+	if !x.refreshed.IsZero() {
+		refreshed := x.refreshed
+		out.Refreshed = &refreshed
+	}
+	return out
+}
+
+// rebuildLocked skips host network pods, which share the namespace of
+// init, and namespaces several pods claim. The runtime does not report
+// HostNetwork, so host network pods are recognized by the inode of
+// /proc/1/ns/net.
+func (x *netnsIndex) rebuildLocked() {
+	pods := map[uint64]ContainerInfo{}
+	ambiguous := map[uint64]bool{}
+	hostIno, _ := netnsInode(x.procRoot, 1)
+	for pid, info := range x.pids.Snapshot() {
+		if info.HostNetwork || info.PodName == "" {
+			continue
+		}
+		ino, err := netnsInode(x.procRoot, pid)
+		if err != nil || (hostIno != 0 && ino == hostIno) {
+			continue
+		}
+		pod := ContainerInfo{
+			Namespace: info.Namespace,
+			PodName:   info.PodName,
+			PodUID:    info.PodUID,
+			PodIP:     info.PodIP,
+		}
+		if prev, ok := pods[ino]; ok && (prev.Namespace != pod.Namespace || prev.PodName != pod.PodName) {
+			ambiguous[ino] = true
+		}
+		pods[ino] = pod
+	}
+	for ino := range ambiguous {
+		delete(pods, ino)
+	}
+	x.pods = pods
+	x.refreshed = x.now()
+}
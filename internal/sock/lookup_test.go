@@ -0,0 +1,147 @@
+package sock
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// netnsFile creates <root>/<pid>/ns/net, hard linked to shareWith's file
+// when it is set so both PIDs report the same namespace inode.
+func netnsFile(t *testing.T, root string, pid, shareWith int) uint64 {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid), "ns")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(dir, "net")
+	if shareWith > 0 {
+		if err := os.Link(filepath.Join(root, strconv.Itoa(shareWith), "ns", "net"), path); err != nil {
+			t.Fatalf("link netns: %v", err)
+		}
+	} else if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("write netns: %v", err)
+	}
+	ino, err := netnsInode(root, pid)
+	if err != nil {
+		t.Fatalf("netnsInode: %v", err)
+	}
+	return ino
+}
+
+func TestNetnsIndex(t *testing.T) {
+	root := t.TempDir()
+	pids := newPIDCache()
+	now := time.Unix(1000, 0)
+	x := newNetnsIndex(root, pids)
+	x.now = func() time.Time { return now }
+
+	web := netnsFile(t, root, 10, 0)
+	netnsFile(t, root, 11, 10) // sidecar in the same pod
+	host := netnsFile(t, root, 20, 0)
+	shared := netnsFile(t, root, 30, 0)
+	hostNetns := netnsFile(t, root, 1, 0)
+	netnsFile(t, root, 50, 1) // host network pod the runtime did not flag
+	netnsFile(t, root, 31, 30)
+
+	pids.Set(10, ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app", ContainerID: "a", PodIP: "10.0.0.5"})
+	pids.Set(11, ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "proxy", ContainerID: "b"})
+	pids.Set(20, ContainerInfo{Namespace: "kube-system", PodName: "node-agent", HostNetwork: true})
+	pids.Set(30, ContainerInfo{Namespace: "a", PodName: "one"})
+	pids.Set(31, ContainerInfo{Namespace: "b", PodName: "two"})
+	pids.Set(50, ContainerInfo{Namespace: "kube-system", PodName: "kube-proxy-x"})
+
+	info, ok := x.Lookup(web)
+	if !ok || info.PodName != "web-1" || info.Namespace != "shop" {
+		t.Fatalf("expected web-1, got %+v (%v)", info, ok)
+	}
+	if info.ContainerName != "" || info.ContainerID != "" {
+		t.Fatalf("netns attribution should be pod-level, got %+v", info)
+	}
+	if _, ok := x.Lookup(host); ok {
+		t.Fatalf("host network pods should not be indexed")
+	}
+	if info, ok := x.Lookup(hostNetns); ok {
+		t.Fatalf("the host namespace should not be indexed, got %+v", info)
+	}
+	if _, ok := x.Lookup(shared); ok {
+		t.Fatalf("namespaces claimed by several pods should not be indexed")
+	}
+
+	// A new pod is picked up on a miss once the miss interval has passed.
+	db := netnsFile(t, root, 40, 0)
+	pids.Set(40, ContainerInfo{Namespace: "shop", PodName: "db-0"})
+	if _, ok := x.Lookup(db); ok {
+		t.Fatalf("expected no rescan right after the last one")
+	}
+	now = now.Add(netnsRefreshMiss)
+	if info, ok := x.Lookup(db); !ok || info.PodName != "db-0" {
+		t.Fatalf("expected db-0 after a rescan, got %+v (%v)", info, ok)
+	}
+}
+
+func TestCachedPIDAndCgroupPID(t *testing.T) {
+	originalCache := cache
+	cache = newPIDCache()
+	t.Cleanup(func() { cache = originalCache })
+
+	originalCgroups := cgroups
+	cgroups = newCgroupResolver(t.TempDir(), cache)
+	t.Cleanup(func() { cgroups = originalCgroups })
+
+	expected := ContainerInfo{Namespace: "ns", PodName: "pod", ContainerName: "ctr"}
+	cache.Set(999, expected)
+	if got, ok := CachedPID(999); !ok || got != expected {
+		t.Fatalf("expected %+v, got %+v (%v)", expected, got, ok)
+	}
+
+	if got, ok := CachedPID(111); ok {
+		t.Fatalf("expected a miss, got %+v", got)
+	}
+	if got, ok := CgroupPID(111, 0); ok {
+		t.Fatalf("expected no attribution without /proc/111/cgroup, got %+v", got)
+	}
+}
+
+func TestDescribeWorkload(t *testing.T) {
+	web := &Workload{OwnerKind: "Deployment", OwnerName: "web", Labels: map[string]string{"team": "shop"}}
+	SetWorkloadSource(fakeWorkloads{"shop/web-1": web})
+	t.Cleanup(func() { SetWorkloadSource(nil) })
+
+	got := Describe(ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app"})
+	if got.Workload != web {
+		t.Fatalf("expected workload %+v, got %+v", web, got.Workload)
+	}
+
+	got = Describe(ContainerInfo{Namespace: "shop", PodName: "gone", ContainerName: "app"})
+	if got.Workload != nil {
+		t.Fatalf("expected no workload for unknown pod, got %+v", got.Workload)
+	}
+}
+
+type fakePIDCgroups map[int]uint64
+
+func (f fakePIDCgroups) CgroupID(pid int) (uint64, bool) {
+	id, ok := f[pid]
+	return id, ok
+}
+
+func TestCgroupPIDUsesKernelCgroupIDs(t *testing.T) {
+	originalCgroups := cgroups
+	cgroups = newCgroupResolver(t.TempDir(), newPIDCache())
+	t.Cleanup(func() { cgroups = originalCgroups })
+
+	// cgroup 7 is known to hold host processes; pid 30 has no /proc entry.
+	cgroups.cgroupIDs[7] = ""
+	if _, ok := CgroupPID(30, 0); ok {
+		t.Fatalf("expected no attribution without a cgroup id")
+	}
+
+	SetPIDCgroups(fakePIDCgroups{30: 7})
+	t.Cleanup(func() { SetPIDCgroups(nil) })
+	if _, ok := CgroupPID(30, 0); !ok {
+		t.Fatalf("expected pid 30 to be attributed through its kernel cgroup id")
+	}
+}
@@ -0,0 +1,127 @@
+package sock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/net-lens/flow-lens/internal/kube"
+)
+
+const defaultMetadataProviders = "runtime"
+
+// MetadataProvider describes a container by its runtime container ID.
+type MetadataProvider interface {
+	ContainerInfo(containerID string) (ContainerInfo, bool)
+}
+
+var (
+	metadataMu sync.RWMutex
+	// runtimeLabels keeps what the runtime reported (containerd/CRI
+	// labels); without it only the container ID is kept from the runtime.
+	runtimeLabels = true
+	apiMetadata   MetadataProvider
+)
+
+// InitMetadata sets up the container metadata providers listed in the
+// comma-separated METADATA_PROVIDERS variable: "runtime" (the default)
+// uses the runtime's labels, "kubernetes" joins container IDs with a
+// node-scoped Pod informer, adding pod IPs and the host network flag. With
+// both, the Kubernetes API takes precedence for the fields it knows.
+func InitMetadata(ctx context.Context) error {
+	useRuntime, useAPI, err := parseMetadataProviders(envOrDefault("METADATA_PROVIDERS", defaultMetadataProviders))
+	if err != nil {
+		return err
+	}
+
+	var provider MetadataProvider
+	if useAPI {
+		nodeName := os.Getenv("NODE_NAME")
+		if nodeName == "" {
+			return fmt.Errorf("the kubernetes metadata provider needs NODE_NAME")
+		}
+		client, err := kube.NewClient()
+		if err != nil {
+			return err
+		}
+		pods, err := newKubeMetadata(client, nodeName)
+		if err != nil {
+			return err
+		}
+		if err := pods.Start(ctx); err != nil {
+			return err
+		}
+		provider = pods
+	}
+
+	setMetadataProviders(useRuntime, provider)
+	return nil
+}
+
+func parseMetadataProviders(value string) (useRuntime, useAPI bool, err error) {
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "runtime":
+			useRuntime = true
+		case "kubernetes":
+			useAPI = true
+		default:
+			return false, false, fmt.Errorf("unknown metadata provider %q", name)
+		}
+	}
+	if !useRuntime && !useAPI {
+		return false, false, fmt.Errorf("no metadata provider selected")
+	}
+	return useRuntime, useAPI, nil
+}
+
+func setMetadataProviders(useRuntime bool, provider MetadataProvider) {
+	metadataMu.Lock()
+	runtimeLabels = useRuntime
+	apiMetadata = provider
+	metadataMu.Unlock()
+
+	var lookup func(string) (ContainerInfo, bool)
+	if provider != nil {
+		lookup = provider.ContainerInfo
+	}
+	cgroups.SetLookup(lookup)
+}
+
+// describe applies the configured providers to what the runtime reported.
+func describe(info ContainerInfo) ContainerInfo {
+	metadataMu.RLock()
+	useRuntime, provider := runtimeLabels, apiMetadata
+	metadataMu.RUnlock()
+
+	if !useRuntime {
+		info = ContainerInfo{ContainerID: info.ContainerID}
+	}
+	if provider == nil || info.ContainerID == "" {
+		return info
+	}
+	if api, ok := provider.ContainerInfo(info.ContainerID); ok {
+		info = mergeContainerInfo(info, api)
+	}
+	return info
+}
+
+// mergeContainerInfo overrides base with the fields set in override.
+func mergeContainerInfo(base, override ContainerInfo) ContainerInfo {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&base.Namespace, override.Namespace)
+	set(&base.PodName, override.PodName)
+	set(&base.ContainerName, override.ContainerName)
+	set(&base.Image, override.Image)
+	set(&base.PodUID, override.PodUID)
+	set(&base.PodIP, override.PodIP)
+	base.HostNetwork = base.HostNetwork || override.HostNetwork
+	return base
+}
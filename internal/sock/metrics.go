@@ -0,0 +1,73 @@
+package sock
+
+import (
+	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	WatcherUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "flow_lens",
+			Subsystem: "runtime_watcher",
+			Name:      "up",
+			Help:      "1 while the containerd event subscription is established and containerd answers health checks",
+		},
+	)
+
+	WatcherReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "runtime_watcher",
+			Name:      "reconnects_total",
+			Help:      "Times the containerd event subscription was re-established",
+		},
+	)
+
+	WatcherResyncs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "runtime_watcher",
+			Name:      "resyncs_total",
+			Help:      "Full container resyncs labeled by reason (start, reconnect, periodic)",
+		},
+		[]string{"reason"},
+	)
+
+	WatcherLastEvent = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "flow_lens",
+			Subsystem: "runtime_watcher",
+			Name:      "last_event_timestamp_seconds",
+			Help:      "Unix time of the last containerd task event received",
+		},
+	)
+
+	AttributionResolvedLate = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "attribution",
+			Name:      "resolved_late_total",
+			Help:      "Deferred events attributed to a container within the grace period",
+		},
+	)
+
+	AttributionGaveUp = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "flow_lens",
+			Subsystem: "attribution",
+			Name:      "gave_up_total",
+			Help:      "Events emitted without attribution labeled by reason (timeout, overflow, shutdown)",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	common.RegisterMetric(WatcherUp)
+	common.RegisterMetric(WatcherReconnects)
+	common.RegisterMetric(WatcherResyncs)
+	common.RegisterMetric(WatcherLastEvent)
+	common.RegisterMetric(AttributionResolvedLate)
+	common.RegisterMetric(AttributionGaveUp)
+}
@@ -0,0 +1,59 @@
+package sock
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd"
+)
+
+// allNamespaces selects every containerd namespace, including ones created
+// after the agent started.
+const allNamespaces = "all"
+
+// namespaceFilter is the set of containerd namespaces the agent attributes.
+type namespaceFilter struct {
+	all   bool
+	names map[string]struct{}
+}
+
+// parseNamespaceFilter parses a comma-separated list such as
+// "k8s.io,moby,default" or "all".
+func parseNamespaceFilter(value string) namespaceFilter {
+	f := namespaceFilter{names: make(map[string]struct{})}
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		switch ns {
+		case "":
+		case allNamespaces:
+			f.all = true
+		default:
+			f.names[ns] = struct{}{}
+		}
+	}
+	return f
+}
+
+// Allows reports whether events from namespace should be processed.
+func (f namespaceFilter) Allows(namespace string) bool {
+	if f.all {
+		return true
+	}
+	_, ok := f.names[namespace]
+	return ok
+}
+
+// List returns the namespaces to scan, asking containerd when all are
+// selected.
+func (f namespaceFilter) List(ctx context.Context, client *containerd.Client) ([]string, error) {
+	if f.all {
+		return client.NamespaceService().List(ctx)
+	}
+	out := make([]string, 0, len(f.names))
+	for ns := range f.names {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package sock
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+)
+
+func TestParseNamespaceFilter(t *testing.T) {
+	f := parseNamespaceFilter(" k8s.io, moby ,,")
+	if f.all {
+		t.Fatalf("expected explicit namespaces, got all")
+	}
+	for _, ns := range []string{"k8s.io", "moby"} {
+		if !f.Allows(ns) {
+			t.Fatalf("expected %q to be allowed", ns)
+		}
+	}
+	if f.Allows("default") {
+		t.Fatalf("expected default to be filtered")
+	}
+
+	if all := parseNamespaceFilter("all"); !all.all || !all.Allows("buildkit") {
+		t.Fatalf("expected all namespaces to be allowed")
+	}
+}
+
+func TestContainerInfoFromMetadata(t *testing.T) {
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name      string
+		namespace string
+		container containers.Container
+		want      ContainerInfo
+	}{
+		{
+			name:      "kubernetes",
+			namespace: "k8s.io",
+			container: containers.Container{
+				ID:    id,
+				Image: "docker.io/library/nginx:1.27",
+				Labels: map[string]string{
+					"io.kubernetes.pod.namespace":  "default",
+					"io.kubernetes.pod.name":       "web-0",
+					"io.kubernetes.container.name": "nginx",
+				},
+			},
+			want: ContainerInfo{Namespace: "default", PodName: "web-0", ContainerName: "nginx", ContainerID: id, Image: "docker.io/library/nginx:1.27"},
+		},
+		{
+			name:      "nerdctl",
+			namespace: "default",
+			container: containers.Container{
+				ID:     id,
+				Image:  "docker.io/library/redis:7",
+				Labels: map[string]string{"nerdctl/name": "cache"},
+			},
+			want: ContainerInfo{Namespace: "default", ContainerName: "cache", ContainerID: id, Image: "docker.io/library/redis:7"},
+		},
+		{
+			name:      "unlabelled",
+			namespace: "moby",
+			container: containers.Container{ID: id, Image: "alpine"},
+			want:      ContainerInfo{Namespace: "moby", ContainerName: "0123456789ab", ContainerID: id, Image: "alpine"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := containerInfoFromMetadata(tt.namespace, tt.container); got != tt.want {
+			t.Fatalf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
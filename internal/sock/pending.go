@@ -0,0 +1,131 @@
+package sock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPendingSize  = 4096
+	DefaultPendingGrace = 5 * time.Second
+
+	pendingRetryInterval = 250 * time.Millisecond
+)
+
+type pendingEvent struct {
+	pid      int
+	deadline time.Time
+	resolve  func() (ContainerInfo, bool)
+	emit     func(ContainerInfo)
+}
+
+// PendingQueue holds events whose PID could not be attributed yet, usually
+// because they race the runtime's container start event, and re-resolves
+// them until a grace period runs out. Events that are never attributed,
+// or that do not fit into the queue, are emitted with an empty
+// ContainerInfo.
+type PendingQueue struct {
+	size     int
+	grace    time.Duration
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	events []pendingEvent
+}
+
+// NewPendingQueue returns a queue holding at most size events for up to
+// grace each. Call Run to process it.
+func NewPendingQueue(size int, grace time.Duration) *PendingQueue {
+	return &PendingQueue{
+		size:     size,
+		grace:    grace,
+		interval: pendingRetryInterval,
+		now:      time.Now,
+	}
+}
+
+// Defer queues emit until resolve attributes pid or the grace period is
+// over. resolve is retried from Run and should repeat the lookups that
+// failed for the event. Defer returns false, without calling emit, when
+// the queue is full or there is no PID to wait for: events from softirq
+// context or on accepted sockets without a recorded owner carry PID 0 and
+// never resolve.
+func (q *PendingQueue) Defer(pid int, resolve func() (ContainerInfo, bool), emit func(ContainerInfo)) bool {
+	if pid <= 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) >= q.size {
+		AttributionGaveUp.WithLabelValues("overflow").Inc()
+		return false
+	}
+	q.events = append(q.events, pendingEvent{pid: pid, deadline: q.now().Add(q.grace), resolve: resolve, emit: emit})
+	return true
+}
+
+// Run retries the queued events until ctx is done, then emits whatever is
+// left unattributed.
+func (q *PendingQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			q.flush()
+			return
+		case <-ticker.C:
+			q.retry()
+		}
+	}
+}
+
+func (q *PendingQueue) retry() {
+	q.mu.Lock()
+	events := q.events
+	q.events = nil
+	q.mu.Unlock()
+
+	now := q.now()
+	var keep []pendingEvent
+	for _, evt := range events {
+		if info, ok := evt.resolve(); ok {
+			AttributionResolvedLate.Inc()
+			evt.emit(info)
+			continue
+		}
+		if !now.Before(evt.deadline) {
+			AttributionGaveUp.WithLabelValues("timeout").Inc()
+			evt.emit(ContainerInfo{})
+			continue
+		}
+		keep = append(keep, evt)
+	}
+
+	// Events deferred while resolving go after the older ones.
+	q.mu.Lock()
+	q.events = append(keep, q.events...)
+	q.mu.Unlock()
+}
+
+func (q *PendingQueue) flush() {
+	q.mu.Lock()
+	events := q.events
+	q.events = nil
+	q.mu.Unlock()
+
+	for _, evt := range events {
+		AttributionGaveUp.WithLabelValues("shutdown").Inc()
+		evt.emit(ContainerInfo{})
+	}
+}
+
+// Len returns the number of queued events.
+func (q *PendingQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
@@ -0,0 +1,104 @@
+package sock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPendingQueueResolvesLateAndGivesUp(t *testing.T) {
+	lateBefore := testutil.ToFloat64(AttributionResolvedLate)
+	timeoutBefore := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("timeout"))
+	overflowBefore := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("overflow"))
+
+	now := time.Unix(0, 0)
+	known := map[int]ContainerInfo{}
+
+	q := NewPendingQueue(2, time.Second)
+	q.now = func() time.Time { return now }
+	resolver := func(pid int) func() (ContainerInfo, bool) {
+		return func() (ContainerInfo, bool) {
+			info, ok := known[pid]
+			return info, ok
+		}
+	}
+
+	emitted := map[int]ContainerInfo{}
+	emitter := func(pid int) func(ContainerInfo) {
+		return func(info ContainerInfo) { emitted[pid] = info }
+	}
+
+	if !q.Defer(10, resolver(10), emitter(10)) || !q.Defer(20, resolver(20), emitter(20)) {
+		t.Fatalf("expected both events to be queued")
+	}
+	if q.Defer(30, resolver(30), emitter(30)) {
+		t.Fatalf("expected a full queue to refuse the event")
+	}
+
+	q.retry()
+	if len(emitted) != 0 || q.Len() != 2 {
+		t.Fatalf("expected events to stay queued, emitted=%v len=%d", emitted, q.Len())
+	}
+
+	web := ContainerInfo{Namespace: "shop", PodName: "web-1"}
+	known[10] = web
+	q.retry()
+	if got, ok := emitted[10]; !ok || got != web {
+		t.Fatalf("expected pid 10 to be emitted with %+v, got %+v (ok=%v)", web, got, ok)
+	}
+
+	now = now.Add(time.Second)
+	q.retry()
+	if got, ok := emitted[20]; !ok || got != (ContainerInfo{}) {
+		t.Fatalf("expected pid 20 to be emitted unattributed, got %+v (ok=%v)", got, ok)
+	}
+	if q.Len() != 0 {
+		t.Fatalf("expected empty queue, got %d", q.Len())
+	}
+
+	if got := testutil.ToFloat64(AttributionResolvedLate) - lateBefore; got != 1 {
+		t.Fatalf("expected 1 late resolution, got %v", got)
+	}
+	if got := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("timeout")) - timeoutBefore; got != 1 {
+		t.Fatalf("expected 1 timeout, got %v", got)
+	}
+	if got := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("overflow")) - overflowBefore; got != 1 {
+		t.Fatalf("expected 1 overflow, got %v", got)
+	}
+}
+
+func TestPendingQueueRefusesPIDZero(t *testing.T) {
+	overflowBefore := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("overflow"))
+
+	q := NewPendingQueue(2, time.Second)
+	never := func() (ContainerInfo, bool) { return ContainerInfo{}, false }
+	if q.Defer(0, never, func(ContainerInfo) { t.Fatalf("emit called by Defer") }) {
+		t.Fatalf("expected an event without a PID not to be queued")
+	}
+	if q.Len() != 0 {
+		t.Fatalf("expected empty queue, got %d", q.Len())
+	}
+	if got := testutil.ToFloat64(AttributionGaveUp.WithLabelValues("overflow")) - overflowBefore; got != 0 {
+		t.Fatalf("expected no overflow, got %v", got)
+	}
+}
+
+func TestPendingQueueFlushesOnShutdown(t *testing.T) {
+	q := NewPendingQueue(4, time.Hour)
+	never := func() (ContainerInfo, bool) { return ContainerInfo{}, false }
+
+	done := make(chan ContainerInfo, 1)
+	q.Defer(10, never, func(info ContainerInfo) { done <- info })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Run(ctx)
+
+	select {
+	case <-done:
+	default:
+		t.Fatalf("expected queued event to be emitted on shutdown")
+	}
+}
@@ -2,49 +2,131 @@ package sock
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"sync"
 
 	"github.com/containerd/containerd"
-	"github.com/containerd/containerd/namespaces"
 )
 
+// Sock attributes a single PID. It runs the same steps as the source
+// enrichers of the enrichment chain (PID cache, cgroup, Describe) for
+// callers outside of it.
 type Sock struct {
 	PID int
 }
 
+const (
+	defaultContainerdSocket = "/run/containerd/containerd.sock"
+	defaultCRIOSocket       = "/var/run/crio/crio.sock"
+	defaultNamespaces       = "k8s.io"
+)
+
 var (
-	clientOnce sync.Once
-	cdClient   *containerd.Client
-	cache      = newPIDCache()
+	runtimeOnce sync.Once
+	runtimeErr  error
+	cdClient    *containerd.Client
+	cache       = newPIDCache()
+	ifaces      = newInterfaceIndex(resolvePeerIfindex)
+	cgroups     = newCgroupResolver("/proc", cache)
+
+	watchedNamespaces = parseNamespaceFilter(defaultNamespaces)
 )
 
-func InitContainerdClient() {
-	clientOnce.Do(func() {
-		sock := os.Getenv("CONTAINERD_SOCKET")
-		if sock == "" {
-			sock = "/run/containerd/containerd.sock"
-		}
+// InitRuntime connects to the container runtime selected by
+// CONTAINER_RUNTIME ("containerd", "cri" or "auto", the default), fills
+// the PID cache with the running containers and keeps it up to date until
+// ctx is done. Failing to reach a runtime is returned to the caller so the
+// agent can keep running without pod attribution.
+func InitRuntime(ctx context.Context) error {
+	runtimeOnce.Do(func() {
+		runtimeErr = initRuntime(ctx, os.Getenv("CONTAINER_RUNTIME"))
+	})
+	return runtimeErr
+}
+
+func initRuntime(ctx context.Context, kind string) error {
+	containerdSocket := envOrDefault("CONTAINERD_SOCKET", defaultContainerdSocket)
 
-		var err error
-		cdClient, err = containerd.New(sock)
-		if err != nil {
-			log.Fatalf("failed to connect to containerd: %v", err)
+	switch kind {
+	case "containerd":
+		return initContainerd(ctx, containerdSocket)
+	case "cri":
+		return initCRI(ctx, criSocket())
+	case "", "auto":
+		// Native containerd events are cheaper than CRI polling, prefer them.
+		if socketExists(containerdSocket) {
+			return initContainerd(ctx, containerdSocket)
 		}
+		if socket := criSocket(); socketExists(socket) {
+			return initCRI(ctx, socket)
+		}
+		return fmt.Errorf("no container runtime socket found (tried %s, %s)", containerdSocket, defaultCRIOSocket)
+	default:
+		return fmt.Errorf("unknown CONTAINER_RUNTIME %q", kind)
+	}
+}
 
-		SetExistingContainersInfo(context.Background(), cdClient, cache)
+func initContainerd(ctx context.Context, socket string) error {
+	watchedNamespaces = parseNamespaceFilter(envOrDefault("CONTAINERD_NAMESPACES", defaultNamespaces))
 
-		// Start watcher
-		go startEventWatcher(context.Background(), cdClient, cache)
-	})
+	client, err := containerd.New(socket)
+	if err != nil {
+		return fmt.Errorf("connect to containerd at %s: %w", socket, err)
+	}
+	cdClient = client
+
+	// Warm the caches before the modules start; the watcher lists again
+	// once its subscription is in place.
+	SetExistingContainersInfo(ctx, cdClient, cache)
+
+	// Start watcher
+	go startEventWatcher(ctx, cdClient, cache)
+	return nil
 }
 
-func (s *Sock) GetContainerInfo(ctx context.Context) (ContainerInfo, error) {
+func initCRI(ctx context.Context, socket string) error {
+	rt, err := dialCRI(socket, cache)
+	if err != nil {
+		return err
+	}
+	if err := rt.sync(ctx); err != nil {
+		rt.Close()
+		return err
+	}
 
-	ctx = namespaces.WithNamespace(ctx, "k8s.io")
+	go rt.run(ctx)
+	return nil
+}
+
+// criSocket returns $CRI_SOCKET or the first well-known CRI socket present.
+func criSocket() string {
+	if socket := os.Getenv("CRI_SOCKET"); socket != "" {
+		return socket
+	}
+	for _, socket := range []string{defaultCRIOSocket, defaultContainerdSocket} {
+		if socketExists(socket) {
+			return socket
+		}
+	}
+	return defaultCRIOSocket
+}
+
+func socketExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
 
-	if info, ok := cache.Get(s.PID); ok {
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (s *Sock) GetContainerInfo(ctx context.Context) (ContainerInfo, error) {
+	if info, ok := s.Resolve(ctx); ok {
 		return info, nil
 	}
 
@@ -53,3 +135,17 @@ func (s *Sock) GetContainerInfo(ctx context.Context) (ContainerInfo, error) {
 	// Not found yet (container may not have started)
 	return ContainerInfo{}, nil
 }
+
+// Resolve is GetContainerInfo for callers that can wait: false means the
+// PID could not be attributed yet, typically because the runtime has not
+// reported its container, and a later call may succeed.
+func (s *Sock) Resolve(ctx context.Context) (ContainerInfo, bool) {
+	info, ok := CachedPID(s.PID)
+	if !ok {
+		info, ok = CgroupPID(s.PID, 0)
+	}
+	if !ok {
+		return ContainerInfo{}, false
+	}
+	return Describe(info), true
+}
@@ -28,6 +28,10 @@ func TestSockGetContainerInfoMiss(t *testing.T) {
 	cache = newPIDCache()
 	t.Cleanup(func() { cache = originalCache })
 
+	originalCgroups := cgroups
+	cgroups = newCgroupResolver(t.TempDir(), cache)
+	t.Cleanup(func() { cgroups = originalCgroups })
+
 	s := &Sock{PID: 111}
 	got, err := s.GetContainerInfo(context.Background())
 	if err != nil {
@@ -37,3 +41,33 @@ func TestSockGetContainerInfoMiss(t *testing.T) {
 		t.Fatalf("expected zero ContainerInfo on miss, got %+v", got)
 	}
 }
+
+type fakeWorkloads map[string]*Workload
+
+func (f fakeWorkloads) Workload(namespace, pod string) (*Workload, bool) {
+	w, ok := f[namespace+"/"+pod]
+	return w, ok
+}
+
+func TestSockGetContainerInfoWorkload(t *testing.T) {
+	originalCache := cache
+	cache = newPIDCache()
+	t.Cleanup(func() { cache = originalCache })
+
+	web := &Workload{OwnerKind: "Deployment", OwnerName: "web", Labels: map[string]string{"team": "shop"}}
+	SetWorkloadSource(fakeWorkloads{"shop/web-1": web})
+	t.Cleanup(func() { SetWorkloadSource(nil) })
+
+	cache.Set(10, ContainerInfo{Namespace: "shop", PodName: "web-1", ContainerName: "app"})
+	cache.Set(11, ContainerInfo{Namespace: "shop", PodName: "gone", ContainerName: "app"})
+
+	got, _ := (&Sock{PID: 10}).GetContainerInfo(context.Background())
+	if got.Workload != web {
+		t.Fatalf("expected workload %+v, got %+v", web, got.Workload)
+	}
+
+	got, _ = (&Sock{PID: 11}).GetContainerInfo(context.Background())
+	if got.Workload != nil {
+		t.Fatalf("expected no workload for unknown pod, got %+v", got.Workload)
+	}
+}
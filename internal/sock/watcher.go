@@ -2,78 +2,216 @@ package sock
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/containerd/containerd"
 	eventstypes "github.com/containerd/containerd/api/events"
 	tasks "github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/namespaces"
 	typeurl "github.com/containerd/typeurl/v2"
 )
 
+const (
+	watcherInitialBackoff    = time.Second
+	watcherMaxBackoff        = 30 * time.Second
+	watcherReconcileInterval = 5 * time.Minute
+)
+
+// eventSource is the subset of *containerd.Client the watcher needs.
+type eventSource interface {
+	Subscribe(ctx context.Context, filters ...string) (ch <-chan *events.Envelope, errs <-chan error)
+	IsServing(ctx context.Context) (bool, error)
+}
+
+// eventWatcher follows containerd task events. Every subscription is
+// followed by a resync of the caches, so containers started before it
+// took effect (or while disconnected) are not missed. When the stream
+// breaks (e.g. containerd restarts) it resubscribes with exponential
+// backoff. A periodic reconcile catches anything missed while connected.
+type eventWatcher struct {
+	source eventSource
+	handle func(ctx context.Context, e *events.Envelope)
+	resync func(ctx context.Context)
+
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	reconcileInterval time.Duration
+}
+
 func startEventWatcher(ctx context.Context, client *containerd.Client, cache *pidCache) {
+	w := &eventWatcher{
+		source: client,
+		handle: func(ctx context.Context, e *events.Envelope) {
+			handleEvent(ctx, client, cache, e)
+		},
+		resync: func(ctx context.Context) {
+			resyncContainers(ctx, client, cache)
+		},
+		initialBackoff:    watcherInitialBackoff,
+		maxBackoff:        watcherMaxBackoff,
+		reconcileInterval: watcherReconcileInterval,
+	}
+	w.run(ctx)
+}
+
+func (w *eventWatcher) run(ctx context.Context) {
+	backoff := w.initialBackoff
+	reason := "start"
+
+	for {
+		started := time.Now()
+		err := w.watch(ctx, reason)
+		WatcherUp.Set(0)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A subscription that stayed healthy for a while earns a fresh backoff.
+		if time.Since(started) > w.maxBackoff {
+			backoff = w.initialBackoff
+		}
+		log.Printf("[containerd watcher] disconnected: %v (reconnecting in %s)", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, w.maxBackoff)
+		reason = "reconnect"
+	}
+}
+
+// watch consumes one subscription until it fails or ctx is done. The
+// caches are resynced once the subscription is in place, labeled reason.
+func (w *eventWatcher) watch(ctx context.Context, reason string) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// subscribe to task events
-	eventsCh, errCh := client.Subscribe(ctx,
+	eventsCh, errCh := w.source.Subscribe(subCtx,
 		"topic==\"/tasks/start\"",
 		"topic==\"/tasks/exit\"",
 	)
 
+	// Subscribe connects in the background; only a containerd that answers
+	// a health check counts as up.
+	serving, err := w.source.IsServing(subCtx)
+	if err != nil {
+		return fmt.Errorf("health check: %w", err)
+	}
+	if !serving {
+		return errors.New("containerd is not serving")
+	}
+	WatcherUp.Set(1)
+	if reason == "reconnect" {
+		WatcherReconnects.Inc()
+	}
+
+	// Listing after subscribing: a container starting in between is seen
+	// twice rather than not at all.
+	w.resyncWithReason(ctx, reason)
+
+	reconcile := time.NewTicker(w.reconcileInterval)
+	defer reconcile.Stop()
+
 	for {
 		select {
-		case evt := <-eventsCh:
-			handleEvent(ctx, client, cache, evt)
+		case evt, ok := <-eventsCh:
+			if !ok {
+				return errors.New("event stream closed")
+			}
+			WatcherLastEvent.SetToCurrentTime()
+			lastEvent.Store(time.Now().Unix())
+			w.handle(ctx, evt)
 		case err, ok := <-errCh:
 			if !ok {
-				return
+				return errors.New("event stream closed")
 			}
 			if err != nil {
-				log.Printf("[containerd watcher] error: %v", err)
+				return err
 			}
+		case <-reconcile.C:
+			w.resyncWithReason(ctx, "periodic")
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		}
 	}
 }
 
-func SetExistingContainersInfo(ctx context.Context, client *containerd.Client, cache *pidCache) {
-	// containerd APIs require an explicit namespace; default to k8s workloads
-	ctx = namespaces.WithNamespace(ctx, "k8s.io")
+func (w *eventWatcher) resyncWithReason(ctx context.Context, reason string) {
+	WatcherResyncs.WithLabelValues(reason).Inc()
+	w.resync(ctx)
+}
 
-	containers, err := client.Containers(ctx)
-	if err != nil {
-		log.Printf("get containers: %v", err)
+// resyncContainers re-lists running containers and forgets the ones that
+// disappeared without an exit event reaching us.
+func resyncContainers(ctx context.Context, client *containerd.Client, cache *pidCache) {
+	live := SetExistingContainersInfo(ctx, client, cache)
+	if live == nil {
 		return
 	}
+	for _, pid := range cgroups.PruneContainers(live) {
+		ifaces.Untrack(pid)
+	}
+}
 
-	for _, container := range containers {
-		info, err := container.Info(ctx)
-		if err != nil {
-			log.Printf("container info: %v", err)
-			continue
-		}
+// SetExistingContainersInfo caches every running container of the
+// watched namespaces and returns their IDs, or nil when containerd could
+// not be listed.
+func SetExistingContainersInfo(ctx context.Context, client *containerd.Client, cache *pidCache) map[string]struct{} {
+	// containerd APIs require an explicit namespace
+	nsList, err := watchedNamespaces.List(ctx, client)
+	if err != nil {
+		log.Printf("list containerd namespaces: %v", err)
+		return nil
+	}
 
-		labels := info.Labels
+	live := make(map[string]struct{})
+	for _, ns := range nsList {
+		nsCtx := namespaces.WithNamespace(ctx, ns)
 
-		// --- Get PID ---
-		task, err := container.Task(ctx, nil)
+		containers, err := client.Containers(nsCtx)
 		if err != nil {
-			log.Printf("container task: %v", err)
-			continue
+			log.Printf("get containers in namespace %s: %v", ns, err)
+			return nil
 		}
 
-		pid := task.Pid()
+		for _, container := range containers {
+			info, err := container.Info(nsCtx)
+			if err != nil {
+				log.Printf("container info: %v", err)
+				continue
+			}
+
+			// --- Get PID ---
+			task, err := container.Task(nsCtx, nil)
+			if err != nil {
+				log.Printf("container task: %v", err)
+				continue
+			}
 
-		cache.Set(int(pid), ContainerInfo{
-			Namespace:     labels["io.kubernetes.pod.namespace"],
-			PodName:       labels["io.kubernetes.pod.name"],
-			ContainerName: labels["io.kubernetes.container.name"],
-		})
-	}
+			pid := task.Pid()
+			live[container.ID()] = struct{}{}
 
+			ci := containerInfoFromMetadata(ns, info)
+			cache.Set(int(pid), ci)
+			cgroups.SetContainer(container.ID(), int(pid), ci)
+			trackInterface(int(pid), ci)
+		}
+	}
+	return live
 }
 
 func handleEvent(ctx context.Context, client *containerd.Client, cache *pidCache, e *events.Envelope) {
+	if !watchedNamespaces.Allows(e.Namespace) {
+		return
+	}
 	ctx = namespaces.WithNamespace(ctx, e.Namespace)
 
 	switch e.Topic {
@@ -112,13 +250,10 @@ func handleEvent(ctx context.Context, client *containerd.Client, cache *pidCache
 			return
 		}
 
-		labels := info.Labels
-
-		cache.Set(int(process.Pid), ContainerInfo{
-			Namespace:     labels["io.kubernetes.pod.namespace"],
-			PodName:       labels["io.kubernetes.pod.name"],
-			ContainerName: labels["io.kubernetes.container.name"],
-		})
+		ci := containerInfoFromMetadata(e.Namespace, info)
+		cache.Set(int(process.Pid), ci)
+		cgroups.SetContainer(start.ContainerID, int(process.Pid), ci)
+		trackInterface(int(process.Pid), ci)
 
 	case "/tasks/exit":
 		var exit eventstypes.TaskExit
@@ -127,5 +262,57 @@ func handleEvent(ctx context.Context, client *containerd.Client, cache *pidCache
 			return
 		}
 		cache.Delete(int(exit.Pid))
+		ifaces.Untrack(int(exit.Pid))
+		// Exec'd processes exit with their own ID; only the init process
+		// ends the container.
+		if exit.ID == exit.ContainerID {
+			cgroups.DeleteContainer(exit.ContainerID)
+		}
+	}
+}
+
+// containerInfoFromMetadata reads the Kubernetes labels set by the CRI
+// plugin. Containers started outside Kubernetes (nerdctl, Docker's "moby"
+// namespace, buildkit, ...) fall back to the containerd namespace, the
+// nerdctl name label or the short container ID, and the image name.
+func containerInfoFromMetadata(namespace string, c containers.Container) ContainerInfo {
+	labels := c.Labels
+
+	ci := ContainerInfo{
+		Namespace:     labels["io.kubernetes.pod.namespace"],
+		PodName:       labels["io.kubernetes.pod.name"],
+		ContainerName: labels["io.kubernetes.container.name"],
+		ContainerID:   c.ID,
+		Image:         c.Image,
+		PodUID:        labels["io.kubernetes.pod.uid"],
+	}
+	if ci.PodName != "" {
+		return ci
+	}
+
+	if ci.Namespace == "" {
+		ci.Namespace = namespace
+	}
+	if ci.ContainerName == "" {
+		ci.ContainerName = labels["nerdctl/name"]
+	}
+	if ci.ContainerName == "" {
+		ci.ContainerName = shortID(c.ID)
+	}
+	return ci
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// trackInterface records the host veth of the task's pod. Failures are
+// logged only: interface attribution is best effort.
+func trackInterface(pid int, info ContainerInfo) {
+	if err := ifaces.Track(pid, info); err != nil {
+		log.Printf("[sock] resolve pod interface for pid %d: %v", pid, err)
 	}
 }
@@ -0,0 +1,219 @@
+package sock
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeEventSource hands out one scripted subscription per Subscribe call
+// and answers health checks with the matching entry of servingErrs.
+type fakeEventSource struct {
+	mu          sync.Mutex
+	subs        []fakeSubscription
+	servingErrs []error
+	calls       int
+	order       []string
+}
+
+type fakeSubscription struct {
+	events chan *events.Envelope
+	errs   chan error
+}
+
+func newFakeSubscription() fakeSubscription {
+	return fakeSubscription{events: make(chan *events.Envelope, 4), errs: make(chan error, 1)}
+}
+
+func (f *fakeEventSource) Subscribe(ctx context.Context, _ ...string) (<-chan *events.Envelope, <-chan error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := f.subs[f.calls]
+	f.calls++
+	f.order = append(f.order, "subscribe")
+	return sub.events, sub.errs
+}
+
+func (f *fakeEventSource) IsServing(context.Context) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := f.calls - 1; i < len(f.servingErrs) && f.servingErrs[i] != nil {
+		return false, f.servingErrs[i]
+	}
+	return true, nil
+}
+
+func (f *fakeEventSource) record(step string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.order = append(f.order, step)
+}
+
+func (f *fakeEventSource) subscribeCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestEventWatcherReconnectsAndResyncs(t *testing.T) {
+	reconnectsBefore := testutil.ToFloat64(WatcherReconnects)
+	resyncsBefore := testutil.ToFloat64(WatcherResyncs.WithLabelValues("reconnect"))
+
+	first, second := newFakeSubscription(), newFakeSubscription()
+	source := &fakeEventSource{subs: []fakeSubscription{first, second}}
+
+	handled := make(chan string, 4)
+	resynced := make(chan struct{}, 4)
+
+	w := &eventWatcher{
+		source: source,
+		handle: func(_ context.Context, e *events.Envelope) { handled <- e.Topic },
+		resync: func(context.Context) {
+			source.record("resync")
+			resynced <- struct{}{}
+		},
+		initialBackoff:    time.Millisecond,
+		maxBackoff:        10 * time.Millisecond,
+		reconcileInterval: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+
+	expectSignal(t, resynced, "resync after the first subscription")
+	first.events <- &events.Envelope{Topic: "/tasks/start"}
+	expectSignal(t, handled, "event on first subscription")
+
+	// containerd restarts: the stream reports an error and closes.
+	first.errs <- errors.New("connection reset")
+	expectSignal(t, resynced, "resync after reconnect")
+
+	second.events <- &events.Envelope{Topic: "/tasks/exit"}
+	if topic := expectSignal(t, handled, "event on second subscription"); topic != "/tasks/exit" {
+		t.Fatalf("unexpected topic %q", topic)
+	}
+
+	if got := source.subscribeCalls(); got != 2 {
+		t.Fatalf("expected 2 subscriptions, got %d", got)
+	}
+	source.mu.Lock()
+	order := append([]string(nil), source.order...)
+	source.mu.Unlock()
+	if want := []string{"subscribe", "resync", "subscribe", "resync"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected every resync to follow its subscription, got %v", order)
+	}
+	if got := testutil.ToFloat64(WatcherReconnects) - reconnectsBefore; got != 1 {
+		t.Fatalf("expected 1 reconnect, got %v", got)
+	}
+	if got := testutil.ToFloat64(WatcherResyncs.WithLabelValues("reconnect")) - resyncsBefore; got != 1 {
+		t.Fatalf("expected 1 reconnect resync, got %v", got)
+	}
+	if got := testutil.ToFloat64(WatcherUp); got != 1 {
+		t.Fatalf("expected watcher to report up, got %v", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("watcher did not stop after cancel")
+	}
+}
+
+func TestEventWatcherNotUpUntilHealthy(t *testing.T) {
+	reconnectsBefore := testutil.ToFloat64(WatcherReconnects)
+
+	down, up := newFakeSubscription(), newFakeSubscription()
+	source := &fakeEventSource{
+		subs:        []fakeSubscription{down, up},
+		servingErrs: []error{errors.New("connection refused")},
+	}
+	resynced := make(chan struct{}, 4)
+
+	w := &eventWatcher{
+		source:            source,
+		handle:            func(context.Context, *events.Envelope) {},
+		resync:            func(context.Context) { resynced <- struct{}{} },
+		initialBackoff:    time.Millisecond,
+		maxBackoff:        time.Millisecond,
+		reconcileInterval: time.Hour,
+	}
+
+	WatcherUp.Set(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	expectSignal(t, resynced, "resync once containerd answers")
+	if got := source.subscribeCalls(); got != 2 {
+		t.Fatalf("expected a failed and a healthy subscription, got %d", got)
+	}
+	if got := testutil.ToFloat64(WatcherUp); got != 1 {
+		t.Fatalf("expected watcher to report up, got %v", got)
+	}
+	if got := testutil.ToFloat64(WatcherReconnects) - reconnectsBefore; got != 1 {
+		t.Fatalf("expected 1 reconnect, got %v", got)
+	}
+}
+
+func TestEventWatcherPeriodicReconcile(t *testing.T) {
+	sub := newFakeSubscription()
+	resynced := make(chan struct{}, 4)
+
+	w := &eventWatcher{
+		source:            &fakeEventSource{subs: []fakeSubscription{sub}},
+		handle:            func(context.Context, *events.Envelope) {},
+		resync:            func(context.Context) { resynced <- struct{}{} },
+		initialBackoff:    time.Millisecond,
+		maxBackoff:        time.Millisecond,
+		reconcileInterval: 5 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	expectSignal(t, resynced, "resync after subscribing")
+	expectSignal(t, resynced, "periodic reconcile")
+}
+
+func TestCgroupResolverPruneContainers(t *testing.T) {
+	pids := newPIDCache()
+	r := newCgroupResolver(t.TempDir(), pids)
+
+	r.SetContainer("live", 10, ContainerInfo{PodName: "a"})
+	r.SetContainer("gone", 20, ContainerInfo{PodName: "b"})
+
+	evicted := r.PruneContainers(map[string]struct{}{"live": {}})
+	if len(evicted) != 1 || evicted[0] != 20 {
+		t.Fatalf("expected pid 20 to be evicted, got %v", evicted)
+	}
+	if _, ok := pids.Get(20); ok {
+		t.Fatalf("expected stale container pid to leave the cache")
+	}
+	if _, ok := pids.Get(10); !ok {
+		t.Fatalf("expected live container pid to stay cached")
+	}
+}
+
+func expectSignal[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,53 @@
+package sock
+
+import "sync"
+
+// Workload is pod metadata the container runtime does not carry: the
+// controller owning the pod and its labels and annotations. It is shared
+// between lookups and must not be modified.
+type Workload struct {
+	OwnerKind   string            `json:"owner_kind,omitempty"`
+	OwnerName   string            `json:"owner_name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// WorkloadSource looks up the Workload of a pod, typically from the
+// Kubernetes API.
+type WorkloadSource interface {
+	Workload(namespace, pod string) (*Workload, bool)
+}
+
+var (
+	workloadMu  sync.RWMutex
+	workloadSrc WorkloadSource
+)
+
+// SetWorkloadSource makes Describe attach workload metadata to pod
+// containers. A nil source disables it.
+func SetWorkloadSource(src WorkloadSource) {
+	workloadMu.Lock()
+	workloadSrc = src
+	workloadMu.Unlock()
+}
+
+// withWorkload fills info.Workload from the configured source. The
+// lookup happens per call rather than when the PID is cached so label
+// changes on running pods are picked up.
+func withWorkload(info ContainerInfo) ContainerInfo {
+	if info.PodName == "" {
+		return info
+	}
+
+	workloadMu.RLock()
+	src := workloadSrc
+	workloadMu.RUnlock()
+	if src == nil {
+		return info
+	}
+
+	if w, ok := src.Workload(info.Namespace, info.PodName); ok {
+		info.Workload = w
+	}
+	return info
+}
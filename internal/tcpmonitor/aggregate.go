@@ -0,0 +1,168 @@
+package tcpmonitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultAggregateInterval is how often the in-kernel counters are drained.
+const DefaultAggregateInterval = 10 * time.Second
+
+// aggBatchSize is how many entries one batch lookup-and-delete moves.
+const aggBatchSize = 256
+
+// aggKey mirrors struct agg_key in tcp_monitor.c.
+type aggKey struct {
+	Netns  uint32
+	PID    uint32
+	Cgroup uint64
+	Saddr  [4]byte
+	Daddr  [4]byte
+	Dport  uint16
+	Pad    uint16
+	State  int32
+	Type   uint32
+	Pad2   uint32
+}
+
+// event rebuilds the fields of an Event that the aggregation key keeps.
+// The source port is dropped in the kernel, so Sport stays 0.
+func (k aggKey) event() Event {
+	return Event{
+		PID:    k.PID,
+		Cgroup: k.Cgroup,
+		State:  k.State,
+		Type:   k.Type,
+		Netns:  k.Netns,
+		Dport:  k.Dport,
+		Family: 2, // AF_INET
+		Saddr:  k.Saddr,
+		Daddr:  k.Daddr,
+	}
+}
+
+// runAggregated drains the tcp_agg map every AggregateInterval until ctx
+// is done, then once more so counts gathered since the last tick are kept.
+func (m *Manager) runAggregated(ctx context.Context) error {
+	agg := m.Collection.Maps["tcp_agg"]
+	if agg == nil {
+		return fmt.Errorf("aggregation map tcp_agg not found")
+	}
+
+	interval := m.AggregateInterval
+	if interval <= 0 {
+		interval = DefaultAggregateInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	drain := m.drainIterate
+	if haveBatchLookupAndDelete() {
+		drain = m.drainBatch
+	} else {
+		log.Printf("[tcpmonitor] no batch map operations (Linux 5.6+), counts bumped while draining may be lost")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			drain(context.Background(), agg)
+			return nil
+		case <-ticker.C:
+			drain(ctx, agg)
+		}
+	}
+}
+
+// haveBatchLookupAndDelete probes a plain hash map: the per-CPU batch call
+// of cilium/ebpf reports a missing batch API as an empty map.
+func haveBatchLookupAndDelete() bool {
+	probe, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		return false
+	}
+	defer probe.Close()
+
+	var cursor ebpf.MapBatchCursor
+	_, err = probe.BatchLookupAndDelete(&cursor, make([]uint32, 1), make([]uint32, 1), nil)
+	return err == nil || errors.Is(err, ebpf.ErrKeyNotExist)
+}
+
+// drainBatch records the entries of the aggregation map, taking each out
+// of the map in the same step it is read so no increment is lost between
+// the two.
+func (m *Manager) drainBatch(ctx context.Context, agg *ebpf.Map) {
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		log.Printf("[tcpmonitor] drain aggregation map: %v", err)
+		return
+	}
+
+	var (
+		cursor ebpf.MapBatchCursor
+		keys   = make([]aggKey, aggBatchSize)
+		perCPU = make([]uint64, aggBatchSize*cpus)
+	)
+	for {
+		n, err := agg.BatchLookupAndDelete(&cursor, keys, perCPU, nil)
+		for i := 0; i < n; i++ {
+			var total uint64
+			for _, v := range perCPU[i*cpus : (i+1)*cpus] {
+				total += v
+			}
+			m.recordAggregated(ctx, keys[i], total)
+		}
+		if errors.Is(err, ebpf.ErrKeyNotExist) || (err == nil && n == 0) {
+			return
+		}
+		if err != nil {
+			log.Printf("[tcpmonitor] drain aggregation map: %v", err)
+			return
+		}
+	}
+}
+
+// drainIterate is drainBatch for kernels without batch operations: every
+// entry is read and then deleted, so increments landing in between are
+// lost.
+func (m *Manager) drainIterate(ctx context.Context, agg *ebpf.Map) {
+	var (
+		key    aggKey
+		perCPU []uint64
+		keys   []aggKey
+		counts []uint64
+	)
+	iter := agg.Iterate()
+	for iter.Next(&key, &perCPU) {
+		var total uint64
+		for _, v := range perCPU {
+			total += v
+		}
+		keys = append(keys, key)
+		counts = append(counts, total)
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("[tcpmonitor] iterate aggregation map: %v", err)
+	}
+
+	for i, k := range keys {
+		if err := agg.Delete(k); err != nil {
+			log.Printf("[tcpmonitor] delete aggregation entry: %v", err)
+		}
+		m.recordAggregated(ctx, k, counts[i])
+	}
+}
+
+func (m *Manager) recordAggregated(ctx context.Context, k aggKey, count uint64) {
+	if count == 0 {
+		return
+	}
+	if err := m.handleEvent(ctx, k.event(), count); err != nil {
+		log.Printf("[tcpmonitor] handle aggregated event: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package tcpmonitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/net-lens/flow-lens/internal/enrich"
+)
+
+func TestAggKeyLayout(t *testing.T) {
+	// struct agg_key in tcp_monitor.c is 40 bytes without implicit padding.
+	if got := binary.Size(aggKey{}); got != 40 {
+		t.Fatalf("aggKey is %d bytes, want 40", got)
+	}
+}
+
+// TestAggKeyMatchesBTF compares aggKey with struct agg_key in the object
+// built by make ebpf.
+func TestAggKeyMatchesBTF(t *testing.T) {
+	if _, err := os.Stat(tcpMonitorObject); errors.Is(err, os.ErrNotExist) {
+		t.Skipf("%s not built, run make ebpf", tcpMonitorObject)
+	}
+	spec, err := btf.LoadSpec(tcpMonitorObject)
+	if err != nil {
+		t.Fatalf("load BTF: %v", err)
+	}
+	var key *btf.Struct
+	if err := spec.TypeByName("agg_key", &key); err != nil {
+		t.Fatalf("find struct agg_key: %v", err)
+	}
+
+	goType := reflect.TypeOf(aggKey{})
+	if int(key.Size) != binary.Size(aggKey{}) || len(key.Members) != goType.NumField() {
+		t.Fatalf("struct agg_key has %d bytes in %d members, aggKey %d in %d",
+			key.Size, len(key.Members), binary.Size(aggKey{}), goType.NumField())
+	}
+	for i, member := range key.Members {
+		field := goType.Field(i)
+		if got, want := uintptr(member.Offset.Bytes()), field.Offset; got != want {
+			t.Errorf("struct agg_key.%s at offset %d, aggKey.%s at %d", member.Name, got, field.Name, want)
+		}
+	}
+}
+
+func TestAggKeyEvent(t *testing.T) {
+	k := aggKey{
+		Netns:  4026531840,
+		PID:    42,
+		Cgroup: 7,
+		Saddr:  [4]byte{10, 0, 0, 1},
+		Daddr:  [4]byte{10, 0, 0, 2},
+		Dport:  443,
+		State:  1,
+		Type:   TypeRetrans,
+	}
+
+	evt := k.event()
+	if evt.PID != 42 || evt.Cgroup != 7 || evt.Netns != k.Netns || evt.Dport != 443 || evt.State != 1 || evt.Type != TypeRetrans {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+	if evt.Family != 2 || evt.Saddr != k.Saddr || evt.Daddr != k.Daddr {
+		t.Fatalf("unexpected addresses in %+v", evt)
+	}
+	if evt.Sport != 0 {
+		t.Fatalf("expected no source port, got %d", evt.Sport)
+	}
+}
+
+// TestDrainBatch needs permission to create BPF maps and skips without.
+func TestDrainBatch(t *testing.T) {
+	agg, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.LRUCPUHash,
+		KeySize:    uint32(binary.Size(aggKey{})),
+		ValueSize:  8,
+		MaxEntries: 1024,
+	})
+	if err != nil {
+		t.Skipf("create BPF map: %v", err)
+	}
+	defer agg.Close()
+	if !haveBatchLookupAndDelete() {
+		t.Skip("no batch map operations")
+	}
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		t.Fatalf("possible CPUs: %v", err)
+	}
+
+	// More flows than one batch holds, each counted twice on CPU 0.
+	const flows = aggBatchSize + 44
+	for i := 0; i < flows; i++ {
+		perCPU := make([]uint64, cpus)
+		perCPU[0] = 2
+		key := aggKey{Dport: uint16(i), Type: TypeRetrans}
+		if err := agg.Put(key, perCPU); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+
+	TCPRetransmit.Reset()
+	m := &Manager{Enrichers: enrich.NewChain()}
+	m.drainBatch(context.Background(), agg)
+
+	if got := testutil.CollectAndCount(TCPRetransmit); got != flows {
+		t.Fatalf("expected %d series, got %d", flows, got)
+	}
+	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(
+		"0.0.0.0", "0.0.0.0", "none", "0.0.0.0", "5", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown",
+	)); got != 2 {
+		t.Fatalf("expected the count of port 5 to be 2, got %v", got)
+	}
+	var key aggKey
+	var perCPU []uint64
+	if iter := agg.Iterate(); iter.Next(&key, &perCPU) {
+		t.Fatalf("expected the map to be empty, found %+v", key)
+	}
+}
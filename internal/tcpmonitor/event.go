@@ -0,0 +1,87 @@
+package tcpmonitor
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/net-lens/flow-lens/internal/common"
+)
+
+// Event is a decoded tcp event: the common header fields it uses and the
+// payload of struct event in tcp_monitor.c.
+type Event struct {
+	Timestamp uint64
+
+	PID uint32
+	// Cgroup is the cgroup id of PID when the kernel recorded it.
+	Cgroup uint64
+	State  int32
+	Type   uint32
+	Netns  uint32
+
+	Sport  uint16
+	Dport  uint16
+	Family uint16
+
+	Saddr   [4]byte
+	Daddr   [4]byte
+	SaddrV6 [16]byte
+	DaddrV6 [16]byte
+
+	// Weight is the number of kernel events this one stands for after
+	// sampling and rate limiting; 0 counts as 1.
+	Weight uint32
+}
+
+// count is the number of kernel events e stands for.
+func (e Event) count() uint64 {
+	return uint64(max(e.Weight, 1))
+}
+
+// Byte offsets of the payload fields of struct event, after the common
+// header. TestEventLayout checks them against the BTF of tcp_monitor.o.
+const (
+	offSport    = 0
+	offDport    = 2
+	offFamily   = 4
+	offState    = 8
+	offSaddr    = 12
+	offDaddr    = 16
+	offSaddrV6  = 20
+	offDaddrV6  = 36
+	offWeight   = 52
+	payloadSize = 56
+)
+
+// events decodes the samples of the events map.
+var events = common.NewEventRegistry[Event]()
+
+func init() {
+	for _, typ := range []uint16{TypeRetrans, TypeSendReset, TypeRecvReset} {
+		events.Register(common.ModuleTCPMonitor, typ, decodePayload)
+	}
+}
+
+// decodePayload fills e from a tcp event without allocating.
+func decodePayload(hdr common.EventHeader, payload []byte, e *Event) error {
+	if len(payload) < payloadSize {
+		return fmt.Errorf("short tcp event payload: %d bytes, want at least %d", len(payload), payloadSize)
+	}
+
+	le := binary.LittleEndian
+	e.Timestamp = hdr.Timestamp
+	e.PID = hdr.PID
+	e.Cgroup = hdr.Cgroup
+	e.Type = uint32(hdr.Type)
+	e.Netns = hdr.Netns
+	e.Sport = le.Uint16(payload[offSport:])
+	e.Dport = le.Uint16(payload[offDport:])
+	e.Family = le.Uint16(payload[offFamily:])
+	e.State = int32(le.Uint32(payload[offState:]))
+	copy(e.Saddr[:], payload[offSaddr:])
+	copy(e.Daddr[:], payload[offDaddr:])
+	copy(e.SaddrV6[:], payload[offSaddrV6:])
+	copy(e.DaddrV6[:], payload[offDaddrV6:])
+	e.Weight = le.Uint32(payload[offWeight:])
+	return nil
+}
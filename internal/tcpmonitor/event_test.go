@@ -0,0 +1,164 @@
+package tcpmonitor
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/net-lens/flow-lens/internal/common"
+)
+
+const tcpMonitorObject = "../../bpf/tcpmonitor/tcp_monitor.o"
+
+// sample builds a raw tcp event of typ with the given payload size.
+func sample(typ uint16, size int) []byte {
+	data := make([]byte, common.EventHeaderSize+size)
+	data[0] = common.EventSchemaVersion
+	data[1] = common.EventHeaderSize
+	data[2] = byte(common.ModuleTCPMonitor)
+	binary.LittleEndian.PutUint16(data[4:], typ)
+	binary.LittleEndian.PutUint16(data[6:], uint16(len(data)))
+	binary.LittleEndian.PutUint64(data[8:], 99)          // timestamp
+	binary.LittleEndian.PutUint64(data[16:], 7)          // cgroup
+	binary.LittleEndian.PutUint32(data[24:], 4026531840) // netns
+	binary.LittleEndian.PutUint32(data[28:], 42)         // pid
+	return data
+}
+
+func TestDecodeEvent(t *testing.T) {
+	data := sample(TypeRetrans, payloadSize)
+	payload := data[common.EventHeaderSize:]
+	binary.LittleEndian.PutUint16(payload[offDport:], 443)
+	binary.LittleEndian.PutUint16(payload[offFamily:], 2)
+	binary.LittleEndian.PutUint32(payload[offState:], 1)
+	copy(payload[offDaddr:], []byte{10, 0, 0, 2})
+	binary.LittleEndian.PutUint32(payload[offWeight:], 17)
+
+	var evt Event
+	if _, err := events.Decode(data, &evt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := Event{
+		Timestamp: 99,
+		PID:       42,
+		Cgroup:    7,
+		State:     1,
+		Type:      TypeRetrans,
+		Netns:     4026531840,
+		Dport:     443,
+		Family:    2,
+		Daddr:     [4]byte{10, 0, 0, 2},
+		Weight:    17,
+	}
+	if evt != want {
+		t.Fatalf("got %+v, want %+v", evt, want)
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = events.Decode(data, &evt) }); allocs != 0 {
+		t.Fatalf("Decode allocated %v times", allocs)
+	}
+}
+
+func TestDecodeEventLongerPayload(t *testing.T) {
+	// A newer object may append payload fields.
+	data := sample(TypeSendReset, payloadSize+8)
+	binary.LittleEndian.PutUint32(data[common.EventHeaderSize+offWeight:], 3)
+
+	var evt Event
+	if _, err := events.Decode(data, &evt); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if evt.Type != TypeSendReset || evt.Weight != 3 {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+}
+
+func TestDecodeShortEvent(t *testing.T) {
+	var evt Event
+	if _, err := events.Decode(sample(TypeRetrans, payloadSize-1), &evt); err == nil {
+		t.Fatalf("expected an error for a short payload")
+	}
+}
+
+func TestDecodeUnknownEventType(t *testing.T) {
+	var evt Event
+	if _, err := events.Decode(sample(9, payloadSize), &evt); !errors.Is(err, common.ErrUnknownEvent) {
+		t.Fatalf("expected ErrUnknownEvent, got %v", err)
+	}
+}
+
+// TestEventLayout fails when struct event in tcp_monitor.c and the
+// decoder disagree. It needs the object built by make ebpf.
+func TestEventLayout(t *testing.T) {
+	if _, err := os.Stat(tcpMonitorObject); errors.Is(err, os.ErrNotExist) {
+		t.Skipf("%s not built, run make ebpf", tcpMonitorObject)
+	}
+
+	spec, err := btf.LoadSpec(tcpMonitorObject)
+	if err != nil {
+		t.Fatalf("load BTF: %v", err)
+	}
+	var event *btf.Struct
+	if err := spec.TypeByName("event", &event); err != nil {
+		t.Fatalf("find struct event: %v", err)
+	}
+
+	fields := map[string]struct {
+		offset int
+		goName string
+	}{
+		"sport":    {offSport, "Sport"},
+		"dport":    {offDport, "Dport"},
+		"family":   {offFamily, "Family"},
+		"state":    {offState, "State"},
+		"saddr":    {offSaddr, "Saddr"},
+		"daddr":    {offDaddr, "Daddr"},
+		"saddr_v6": {offSaddrV6, "SaddrV6"},
+		"daddr_v6": {offDaddrV6, "DaddrV6"},
+		"weight":   {offWeight, "Weight"},
+	}
+
+	goType := reflect.TypeOf(Event{})
+	seen := 0
+	for _, member := range event.Members {
+		size, err := btf.Sizeof(member.Type)
+		if err != nil {
+			t.Fatalf("size of struct event.%s: %v", member.Name, err)
+		}
+		offset := int(member.Offset.Bytes())
+
+		if member.Name == "hdr" {
+			if offset != 0 || size != common.EventHeaderSize {
+				t.Errorf("struct event.hdr is %d bytes at offset %d, want %d at 0", size, offset, common.EventHeaderSize)
+			}
+			continue
+		}
+		if strings.HasPrefix(member.Name, "_") {
+			continue
+		}
+		field, ok := fields[member.Name]
+		if !ok {
+			t.Fatalf("struct event.%s is not decoded", member.Name)
+		}
+		seen++
+
+		if got := offset - common.EventHeaderSize; got != field.offset {
+			t.Errorf("struct event.%s is at payload offset %d, decoder reads %d", member.Name, got, field.offset)
+		}
+		goField, _ := goType.FieldByName(field.goName)
+		if uintptr(size) != goField.Type.Size() {
+			t.Errorf("struct event.%s is %d bytes, Event.%s is %d", member.Name, size, field.goName, goField.Type.Size())
+		}
+	}
+	if seen != len(fields) {
+		t.Errorf("struct event has %d payload fields, the decoder reads %d", seen, len(fields))
+	}
+	if got := int(event.Size) - common.EventHeaderSize; got != payloadSize {
+		t.Errorf("struct event payload is %d bytes, payloadSize is %d", got, payloadSize)
+	}
+}
@@ -0,0 +1,339 @@
+package tcpmonitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// Mirrors the FILTER_* constants in tcp_monitor.c.
+const (
+	filterNetnsAllow = 1 << 0
+	filterPorts      = 1 << 1
+	filterCIDRs      = 1 << 2
+
+	filterAllow = 1
+	filterDeny  = 2
+
+	maxPortRanges = 16
+
+	filterFilePollInterval = 10 * time.Second
+)
+
+var eventTypes = map[string]int{
+	"retransmit": TypeRetrans,
+	"send_reset": TypeSendReset,
+	"recv_reset": TypeRecvReset,
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Low, High uint16
+}
+
+// Filter selects the tcp events the kernel hands to userspace. Empty
+// fields do not filter.
+type Filter struct {
+	// AllowNetns keeps only events from these network namespaces (inodes).
+	AllowNetns []uint32
+	// DenyNetns drops events from these network namespaces.
+	DenyNetns []uint32
+	// Ports keeps events whose source or destination port is in a range.
+	Ports []PortRange
+	// CIDRs keeps events whose source or destination address is inside.
+	CIDRs []*net.IPNet
+	// Types keeps only these event types (TypeRetrans, ...).
+	Types []int
+}
+
+// filterConfig mirrors struct filter_config in tcp_monitor.c.
+type filterConfig struct {
+	Flags   uint32
+	Types   uint32
+	NrPorts uint32
+	Ports   [maxPortRanges]PortRange
+}
+
+type cidrKey struct {
+	Prefixlen uint32
+	Addr      [4]byte
+}
+
+// ParseFilter builds a Filter from the TCP_FILTER_* variables returned by
+// getenv, e.g. os.Getenv.
+func ParseFilter(getenv func(string) string) (Filter, error) {
+	var f Filter
+	var err error
+
+	if f.AllowNetns, err = parseNetns(getenv("TCP_FILTER_NETNS_ALLOW")); err != nil {
+		return Filter{}, fmt.Errorf("TCP_FILTER_NETNS_ALLOW: %w", err)
+	}
+	if f.DenyNetns, err = parseNetns(getenv("TCP_FILTER_NETNS_DENY")); err != nil {
+		return Filter{}, fmt.Errorf("TCP_FILTER_NETNS_DENY: %w", err)
+	}
+	if f.Ports, err = parsePortRanges(getenv("TCP_FILTER_PORTS")); err != nil {
+		return Filter{}, fmt.Errorf("TCP_FILTER_PORTS: %w", err)
+	}
+	for _, item := range splitList(getenv("TCP_FILTER_CIDRS")) {
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			return Filter{}, fmt.Errorf("TCP_FILTER_CIDRS: %w", err)
+		}
+		if cidr.IP.To4() == nil {
+			return Filter{}, fmt.Errorf("TCP_FILTER_CIDRS: %s: only IPv4 is supported", cidr)
+		}
+		f.CIDRs = append(f.CIDRs, cidr)
+	}
+	for _, item := range splitList(getenv("TCP_FILTER_EVENT_TYPES")) {
+		typ, ok := eventTypes[item]
+		if !ok {
+			return Filter{}, fmt.Errorf("TCP_FILTER_EVENT_TYPES: unknown event type %q", item)
+		}
+		f.Types = append(f.Types, typ)
+	}
+	return f, nil
+}
+
+// ReadFilterFile parses a file of TCP_FILTER_*=value lines, the format of
+// an env file or a ConfigMap rendered into one. Blank lines and lines
+// starting with # are ignored.
+func ReadFilterFile(path string) (Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Filter{}, err
+	}
+	return parseFilterFile(data)
+}
+
+func parseFilterFile(data []byte) (Filter, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return Filter{}, fmt.Errorf("expected KEY=value, got %q", line)
+		}
+		vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return Filter{}, err
+	}
+	return ParseFilter(func(key string) string { return vars[key] })
+}
+
+func parseNetns(value string) ([]uint32, error) {
+	var out []uint32
+	for _, item := range splitList(value) {
+		n, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid netns inode %q", item)
+		}
+		out = append(out, uint32(n))
+	}
+	return out, nil
+}
+
+// parsePortRanges parses "80,443,8000-8100".
+func parsePortRanges(value string) ([]PortRange, error) {
+	var out []PortRange
+	for _, item := range splitList(value) {
+		low, high, isRange := strings.Cut(item, "-")
+		lo, err := strconv.ParseUint(strings.TrimSpace(low), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.ParseUint(strings.TrimSpace(high), 10, 16); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid port range %q", item)
+			}
+		}
+		out = append(out, PortRange{Low: uint16(lo), High: uint16(hi)})
+	}
+	if len(out) > maxPortRanges {
+		return nil, fmt.Errorf("at most %d port ranges are supported", maxPortRanges)
+	}
+	return out, nil
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// empty reports whether f keeps every event.
+func (f Filter) empty() bool {
+	return len(f.AllowNetns) == 0 && len(f.DenyNetns) == 0 && len(f.Ports) == 0 &&
+		len(f.CIDRs) == 0 && len(f.Types) == 0
+}
+
+func (f Filter) config() filterConfig {
+	var cfg filterConfig
+	if len(f.AllowNetns) > 0 {
+		cfg.Flags |= filterNetnsAllow
+	}
+	if len(f.Ports) > 0 {
+		cfg.Flags |= filterPorts
+		cfg.NrPorts = uint32(copy(cfg.Ports[:], f.Ports))
+	}
+	if len(f.CIDRs) > 0 {
+		cfg.Flags |= filterCIDRs
+	}
+	for _, typ := range f.Types {
+		cfg.Types |= 1 << uint(typ)
+	}
+	return cfg
+}
+
+func (f Filter) netnsVerdicts() map[uint32]uint8 {
+	verdicts := make(map[uint32]uint8, len(f.AllowNetns)+len(f.DenyNetns))
+	for _, ns := range f.AllowNetns {
+		verdicts[ns] = filterAllow
+	}
+	// A namespace in both lists is denied.
+	for _, ns := range f.DenyNetns {
+		verdicts[ns] = filterDeny
+	}
+	return verdicts
+}
+
+func (f Filter) cidrKeys() map[cidrKey]struct{} {
+	keys := make(map[cidrKey]struct{}, len(f.CIDRs))
+	for _, cidr := range f.CIDRs {
+		ones, _ := cidr.Mask.Size()
+		key := cidrKey{Prefixlen: uint32(ones)}
+		copy(key.Addr[:], cidr.IP.To4())
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// SetFilter replaces the kernel-side filter; it can be called while the
+// programs run. New map entries are written before the config that enables
+// them and stale ones are removed after, so events matching both the old
+// and the new filter are never dropped during the switch.
+func (m *Manager) SetFilter(f Filter) error {
+	if m.Collection == nil {
+		return fmt.Errorf("collection not loaded")
+	}
+	m.filterMu.Lock()
+	defer m.filterMu.Unlock()
+
+	cfgMap := m.Collection.Maps["filter_config"]
+	netnsMap := m.Collection.Maps["filter_netns"]
+	cidrMap := m.Collection.Maps["filter_cidrs"]
+	if cfgMap == nil || netnsMap == nil || cidrMap == nil {
+		if f.empty() {
+			return nil
+		}
+		return fmt.Errorf("BPF object has no filter maps")
+	}
+
+	verdicts := f.netnsVerdicts()
+	for ns, verdict := range verdicts {
+		if err := netnsMap.Put(ns, verdict); err != nil {
+			return fmt.Errorf("put netns %d: %w", ns, err)
+		}
+	}
+	cidrs := f.cidrKeys()
+	for key := range cidrs {
+		if err := cidrMap.Put(key, uint8(1)); err != nil {
+			return fmt.Errorf("put cidr %v/%d: %w", net.IP(key.Addr[:]), key.Prefixlen, err)
+		}
+	}
+
+	if err := cfgMap.Put(uint32(0), f.config()); err != nil {
+		return fmt.Errorf("put filter config: %w", err)
+	}
+
+	var stale []error
+	var ns uint32
+	var verdict uint8
+	var nsStale []uint32
+	iter := netnsMap.Iterate()
+	for iter.Next(&ns, &verdict) {
+		if _, ok := verdicts[ns]; !ok {
+			nsStale = append(nsStale, ns)
+		}
+	}
+	stale = append(stale, iter.Err())
+	for _, ns := range nsStale {
+		if err := netnsMap.Delete(ns); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			stale = append(stale, err)
+		}
+	}
+
+	var key cidrKey
+	var cidrStale []cidrKey
+	iter = cidrMap.Iterate()
+	for iter.Next(&key, &verdict) {
+		if _, ok := cidrs[key]; !ok {
+			cidrStale = append(cidrStale, key)
+		}
+	}
+	stale = append(stale, iter.Err())
+	for _, key := range cidrStale {
+		if err := cidrMap.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			stale = append(stale, err)
+		}
+	}
+
+	if err := errors.Join(stale...); err != nil {
+		return fmt.Errorf("remove stale filter entries: %w", err)
+	}
+	return nil
+}
+
+// watchFilterFile reapplies FilterFile whenever its content changes.
+func (m *Manager) watchFilterFile(ctx context.Context, applied []byte) {
+	ticker := time.NewTicker(filterFilePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(m.FilterFile)
+		if err != nil {
+			log.Printf("[tcpmonitor] read filter file: %v", err)
+			continue
+		}
+		if bytes.Equal(data, applied) {
+			continue
+		}
+		f, err := parseFilterFile(data)
+		if err != nil {
+			log.Printf("[tcpmonitor] keeping the current filter, %s: %v", m.FilterFile, err)
+			applied = data
+			continue
+		}
+		if err := m.SetFilter(f); err != nil {
+			log.Printf("[tcpmonitor] update filter: %v", err)
+			continue
+		}
+		applied = data
+		log.Printf("[tcpmonitor] applied filter from %s", m.FilterFile)
+	}
+}
@@ -0,0 +1,96 @@
+package tcpmonitor
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	env := map[string]string{
+		"TCP_FILTER_NETNS_ALLOW": "4026531840, 4026532001",
+		"TCP_FILTER_NETNS_DENY":  "4026532001",
+		"TCP_FILTER_PORTS":       "443,8000-8100",
+		"TCP_FILTER_CIDRS":       "10.0.0.0/8",
+		"TCP_FILTER_EVENT_TYPES": "retransmit,recv_reset",
+	}
+
+	f, err := ParseFilter(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("ParseFilter: %v", err)
+	}
+
+	if want := []PortRange{{443, 443}, {8000, 8100}}; !reflect.DeepEqual(f.Ports, want) {
+		t.Fatalf("ports = %v, want %v", f.Ports, want)
+	}
+	if len(f.CIDRs) != 1 || f.CIDRs[0].String() != "10.0.0.0/8" {
+		t.Fatalf("cidrs = %v", f.CIDRs)
+	}
+
+	cfg := f.config()
+	if cfg.Flags != filterNetnsAllow|filterPorts|filterCIDRs {
+		t.Fatalf("flags = %b", cfg.Flags)
+	}
+	if cfg.Types != 1<<TypeRetrans|1<<TypeRecvReset {
+		t.Fatalf("types = %b", cfg.Types)
+	}
+	if cfg.NrPorts != 2 || cfg.Ports[1] != (PortRange{8000, 8100}) {
+		t.Fatalf("port config = %d %v", cfg.NrPorts, cfg.Ports[:2])
+	}
+
+	verdicts := f.netnsVerdicts()
+	if verdicts[4026531840] != filterAllow || verdicts[4026532001] != filterDeny {
+		t.Fatalf("verdicts = %v", verdicts)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		"TCP_FILTER_PORTS":       "90-80",
+		"TCP_FILTER_NETNS_ALLOW": "abc",
+		"TCP_FILTER_CIDRS":       "fd00::/8",
+		"TCP_FILTER_EVENT_TYPES": "syn",
+	}
+	for key, value := range tests {
+		_, err := ParseFilter(func(k string) string {
+			if k == key {
+				return value
+			}
+			return ""
+		})
+		if err == nil {
+			t.Fatalf("%s=%s: expected an error", key, value)
+		}
+	}
+}
+
+func TestEmptyFilter(t *testing.T) {
+	f, err := ParseFilter(func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("ParseFilter: %v", err)
+	}
+	if !f.empty() || f.config() != (filterConfig{}) {
+		t.Fatalf("expected an empty filter, got %+v", f)
+	}
+}
+
+func TestParseFilterFile(t *testing.T) {
+	f, err := parseFilterFile([]byte("# shared cluster\nTCP_FILTER_PORTS = 5432\n\nTCP_FILTER_EVENT_TYPES=send_reset\n"))
+	if err != nil {
+		t.Fatalf("parseFilterFile: %v", err)
+	}
+	if !reflect.DeepEqual(f.Ports, []PortRange{{5432, 5432}}) || !reflect.DeepEqual(f.Types, []int{TypeSendReset}) {
+		t.Fatalf("unexpected filter %+v", f)
+	}
+
+	if _, err := parseFilterFile([]byte("TCP_FILTER_PORTS\n")); err == nil {
+		t.Fatalf("expected an error for a line without =")
+	}
+}
+
+func TestFilterConfigLayout(t *testing.T) {
+	// struct filter_config in tcp_monitor.c: 3 u32 and 16 u16 pairs.
+	if got := binary.Size(filterConfig{}); got != 76 {
+		t.Fatalf("filterConfig is %d bytes, want 76", got)
+	}
+}
@@ -1,22 +1,46 @@
 package tcpmonitor
 
 import (
-	"fmt"
+	"log"
+	"strings"
 
 	"github.com/net-lens/flow-lens/internal/common"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type TCPMetric struct {
-	SourceIP        string
-	DestinationIP   string
-	SourcePort      string
-	DestinationPort string
-	TargetPod       string
-	TargetContainer string
-	TargetNamespace string
-	Type            int // 1 = RETRANS
-	State           int // 1 = SYN_SENT, 2 = SYN_RECV, 3 = ESTABLISHED, 4 = FIN_WAIT_1, 5 = FIN_WAIT_2, 6 = CLOSE_WAIT, 7 = CLOSING, 8 = LAST_ACK, 9 = TIME_WAIT, 10 = CLOSED, 11 = LISTEN, 12 = CLOSED_WAIT_2, 13 = CLOSING_2, 14 = LAST_ACK_2, 15 = TIME_WAIT_2, 16 = CLOSED_2
+	SourceIP      string `json:"source_ip"`
+	DestinationIP string `json:"destination_ip"`
+	// DestinationServiceIP is the pre-DNAT destination (e.g. a ClusterIP),
+	// DestinationBackendIP the destination after conntrack translation.
+	DestinationServiceIP string `json:"destination_service_ip"`
+	DestinationBackendIP string `json:"destination_backend_ip"`
+	// Workload behind the (backend) destination, from the Kubernetes API.
+	DestinationPod       string `json:"destination_pod"`
+	DestinationNamespace string `json:"destination_namespace"`
+	DestinationService   string `json:"destination_service"`
+	// DestinationName is the reverse DNS name of the destination. It is
+	// not a metric label.
+	DestinationName string `json:"destination_name,omitempty"`
+	SourcePort      string `json:"source_port"`
+	DestinationPort string `json:"destination_port"`
+	TargetPod       string `json:"target_pod"`
+	TargetContainer string `json:"target_container"`
+	TargetNamespace string `json:"target_namespace"`
+	// Owning workload and pod metadata of the target, when known.
+	TargetOwnerKind   string            `json:"target_owner_kind"`
+	TargetOwnerName   string            `json:"target_owner_name"`
+	TargetLabels      map[string]string `json:"target_labels,omitempty"`
+	TargetAnnotations map[string]string `json:"target_annotations,omitempty"`
+	// Host process behind events outside containers, see
+	// LabelOptions.HostProcess.
+	TargetProcess string `json:"target_process,omitempty"`
+	TargetUnit    string `json:"target_unit,omitempty"`
+	// Count is the number of kernel events this metric stands for; 0 is
+	// treated as 1.
+	Count uint64 `json:"count"`
+	Type  int    `json:"type"`  // 1 = RETRANS
+	State int    `json:"state"` // 1 = SYN_SENT, 2 = SYN_RECV, 3 = ESTABLISHED, 4 = FIN_WAIT_1, 5 = FIN_WAIT_2, 6 = CLOSE_WAIT, 7 = CLOSING, 8 = LAST_ACK, 9 = TIME_WAIT, 10 = CLOSED, 11 = LISTEN, 12 = CLOSED_WAIT_2, 13 = CLOSING_2, 14 = LAST_ACK_2, 15 = TIME_WAIT_2, 16 = CLOSED_2
 }
 
 const (
@@ -48,6 +72,13 @@ func stateLabel(state int) string {
 	return "unknown"
 }
 
+func labelOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
 func labelOrUnknown(value string) string {
 	if value == "" {
 		return "unknown"
@@ -55,7 +86,133 @@ func labelOrUnknown(value string) string {
 	return value
 }
 
+// LabelOptions selects the optional labels of the tcp series.
+type LabelOptions struct {
+	// PodLabels and PodAnnotations are the keys copied onto every series
+	// as target_label_<key> and target_annotation_<key>.
+	PodLabels      []string
+	PodAnnotations []string
+	// HostProcess adds the "process" and "unit" labels, which name host
+	// processes outside containers.
+	HostProcess bool
+}
+
+// promoted holds the pod labels and annotations copied onto every tcp
+// series, as set by ConfigureLabels.
+var promoted promotedLabels
+
+type promotedKey struct {
+	annotation bool
+	key        string
+	name       string
+}
+
+type promotedLabels []promotedKey
+
+// parsePromotedLabels turns label and annotation keys into metric labels
+// named target_label_<key> and target_annotation_<key>, with characters
+// Prometheus does not allow replaced by "_". Blank keys are skipped.
+func parsePromotedLabels(labels, annotations []string) promotedLabels {
+	var out promotedLabels
+	seen := map[string]bool{}
+	add := func(list []string, prefix string, annotation bool) {
+		for _, key := range list {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			name := prefix + sanitizeLabelName(key)
+			if seen[name] {
+				log.Printf("[tcpmonitor] ignoring %q, %s is already promoted", key, name)
+				continue
+			}
+			seen[name] = true
+			out = append(out, promotedKey{annotation: annotation, key: key, name: name})
+		}
+	}
+	add(labels, "target_label_", false)
+	add(annotations, "target_annotation_", true)
+	return out
+}
+
+func sanitizeLabelName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func (p promotedLabels) names() []string {
+	names := make([]string, len(p))
+	for i, k := range p {
+		names[i] = k.name
+	}
+	return names
+}
+
+func (p promotedLabels) values(tcpMetric TCPMetric) []string {
+	values := make([]string, len(p))
+	for i, k := range p {
+		if k.annotation {
+			values[i] = labelOrNone(tcpMetric.TargetAnnotations[k.key])
+		} else {
+			values[i] = labelOrNone(tcpMetric.TargetLabels[k.key])
+		}
+	}
+	return values
+}
+
+// withHostProcess is LabelOptions.HostProcess, as set by ConfigureLabels.
+var withHostProcess bool
+
+func hostProcessNames() []string {
+	if !withHostProcess {
+		return nil
+	}
+	return []string{"process", "unit"}
+}
+
+func hostProcessValues(tcpMetric TCPMetric) []string {
+	if !withHostProcess {
+		return nil
+	}
+	return []string{labelOrNone(tcpMetric.TargetProcess), labelOrNone(tcpMetric.TargetUnit)}
+}
+
+// tcpLabels are shared by every tcp series; reset_total adds "direction".
+var tcpLabels []string
+
 var (
+	TCPRetransmit *prometheus.CounterVec
+	TCPReset      *prometheus.CounterVec
+)
+
+// ConfigureLabels sets the optional labels and rebuilds TCPRetransmit and
+// TCPReset with them. It is meant to be called at startup, before any
+// event is recorded.
+func ConfigureLabels(opts LabelOptions) {
+	promoted = parsePromotedLabels(opts.PodLabels, opts.PodAnnotations)
+	withHostProcess = opts.HostProcess
+
+	tcpLabels = append([]string{
+		"source_ip",
+		"destination_ip",
+		"destination_service_ip",
+		"destination_backend_ip",
+		"destination_port",
+		"destination_pod",
+		"destination_namespace",
+		"destination_service",
+		"target_pod",
+		"target_container",
+		"target_namespace",
+		"target_owner_kind",
+		"target_owner_name",
+		"state",
+	}, append(hostProcessNames(), promoted.names()...)...)
+
 	TCPRetransmit = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "flow_lens",
@@ -63,15 +220,7 @@ var (
 			Name:      "retransmit_total",
 			Help:      "TCP retransmissions labeled by src/dst addresses",
 		},
-		[]string{
-			"source_ip",
-			"destination_ip",
-			"destination_port",
-			"target_pod",
-			"target_container",
-			"target_namespace",
-			"state",
-		},
+		tcpLabels,
 	)
 
 	TCPReset = prometheus.NewCounterVec(
@@ -81,60 +230,58 @@ var (
 			Name:      "reset_total",
 			Help:      "TCP resets labeled by src/dst addresses",
 		},
-		[]string{
-			"source_ip",
-			"destination_ip",
-			"destination_port",
-			"target_pod",
-			"target_container",
-			"target_namespace",
-			"state",
-			"direction",
-		},
+		append(tcpLabels[:len(tcpLabels):len(tcpLabels)], "direction"),
 	)
-)
+}
+
+// tcpCollector exports the vectors ConfigureLabels built last. It
+// describes nothing, so the registry accepts their label names changing.
+type tcpCollector struct{}
+
+func (tcpCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (tcpCollector) Collect(ch chan<- prometheus.Metric) {
+	TCPRetransmit.Collect(ch)
+	TCPReset.Collect(ch)
+}
+
+// tcpLabelValues returns the values for tcpLabels, in order.
+func tcpLabelValues(tcpMetric TCPMetric) []string {
+	return append([]string{
+		tcpMetric.SourceIP,
+		tcpMetric.DestinationIP,
+		labelOrNone(tcpMetric.DestinationServiceIP),
+		labelOrUnknown(tcpMetric.DestinationBackendIP),
+		tcpMetric.DestinationPort,
+		labelOrUnknown(tcpMetric.DestinationPod),
+		labelOrUnknown(tcpMetric.DestinationNamespace),
+		labelOrUnknown(tcpMetric.DestinationService),
+		labelOrUnknown(tcpMetric.TargetPod),
+		labelOrUnknown(tcpMetric.TargetContainer),
+		labelOrUnknown(tcpMetric.TargetNamespace),
+		labelOrUnknown(tcpMetric.TargetOwnerKind),
+		labelOrUnknown(tcpMetric.TargetOwnerName),
+		stateLabel(tcpMetric.State),
+	}, append(hostProcessValues(tcpMetric), promoted.values(tcpMetric)...)...)
+}
 
 func MetricIdentifier(tcpMetric TCPMetric) {
+	count := float64(tcpMetric.Count)
+	if count == 0 {
+		count = 1
+	}
+
 	switch tcpMetric.Type {
 	case TypeRetrans:
-		fmt.Println("TCP retransmission detected")
-		TCPRetransmit.WithLabelValues(
-			tcpMetric.SourceIP,
-			tcpMetric.DestinationIP,
-			tcpMetric.DestinationPort,
-			labelOrUnknown(tcpMetric.TargetPod),
-			labelOrUnknown(tcpMetric.TargetContainer),
-			labelOrUnknown(tcpMetric.TargetNamespace),
-			stateLabel(tcpMetric.State),
-		).Inc()
+		TCPRetransmit.WithLabelValues(tcpLabelValues(tcpMetric)...).Add(count)
 	case TypeSendReset:
-		fmt.Println("TCP send reset detected")
-		TCPReset.WithLabelValues(
-			tcpMetric.SourceIP,
-			tcpMetric.DestinationIP,
-			tcpMetric.DestinationPort,
-			labelOrUnknown(tcpMetric.TargetPod),
-			labelOrUnknown(tcpMetric.TargetContainer),
-			labelOrUnknown(tcpMetric.TargetNamespace),
-			stateLabel(tcpMetric.State),
-			"outbound",
-		).Inc()
+		TCPReset.WithLabelValues(append(tcpLabelValues(tcpMetric), "outbound")...).Add(count)
 	case TypeRecvReset:
-		fmt.Println("TCP reset detected")
-		TCPReset.WithLabelValues(
-			tcpMetric.SourceIP,
-			tcpMetric.DestinationIP,
-			tcpMetric.DestinationPort,
-			labelOrUnknown(tcpMetric.TargetPod),
-			labelOrUnknown(tcpMetric.TargetContainer),
-			labelOrUnknown(tcpMetric.TargetNamespace),
-			stateLabel(tcpMetric.State),
-			"inbound",
-		).Inc()
+		TCPReset.WithLabelValues(append(tcpLabelValues(tcpMetric), "inbound")...).Add(count)
 	}
 }
 
 func init() {
-	common.RegisterMetric(TCPRetransmit)
-	common.RegisterMetric(TCPReset)
+	ConfigureLabels(LabelOptions{})
+	common.RegisterMetric(tcpCollector{})
 }
@@ -1,9 +1,12 @@
 package tcpmonitor
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/net-lens/flow-lens/internal/common"
 )
 
 func TestLabelOrUnknown(t *testing.T) {
@@ -25,14 +28,16 @@ func TestMetricIdentifierRetransmit(t *testing.T) {
 	TCPRetransmit.Reset()
 
 	metric := TCPMetric{
-		SourceIP:        "10.0.0.1",
-		DestinationIP:   "10.0.0.2",
-		SourcePort:      "12345",
-		DestinationPort: "80",
-		TargetPod:       "",
-		TargetContainer: "ctr",
-		TargetNamespace: "ns",
-		Type:            1,
+		SourceIP:             "10.0.0.1",
+		DestinationIP:        "10.0.0.2",
+		DestinationServiceIP: "",
+		DestinationBackendIP: "10.0.0.2",
+		SourcePort:           "12345",
+		DestinationPort:      "80",
+		TargetPod:            "",
+		TargetContainer:      "ctr",
+		TargetNamespace:      "ns",
+		Type:                 1,
 	}
 
 	MetricIdentifier(metric)
@@ -40,11 +45,18 @@ func TestMetricIdentifierRetransmit(t *testing.T) {
 	labels := []string{
 		metric.SourceIP,
 		metric.DestinationIP,
-		metric.SourcePort,
+		"none", // no DNAT
+		metric.DestinationBackendIP,
 		metric.DestinationPort,
+		"unknown", // destination pod not resolved
+		"unknown",
+		"unknown",
 		"unknown", // TargetPod empty → unknown
 		metric.TargetContainer,
 		metric.TargetNamespace,
+		"unknown", // no owner
+		"unknown",
+		"unknown", // State 0 → unknown
 	}
 
 	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(labels...)); got != 1 {
@@ -58,8 +70,109 @@ func TestMetricIdentifierIgnoresOtherTypes(t *testing.T) {
 	MetricIdentifier(TCPMetric{Type: 0})
 
 	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(
-		"", "", "", "", "unknown", "unknown", "unknown",
+		"", "", "none", "unknown", "", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown",
 	)); got != 0 {
 		t.Fatalf("expected zero increment, got %v", got)
 	}
 }
+
+func TestMetricIdentifierResetServiceLabels(t *testing.T) {
+	TCPReset.Reset()
+
+	MetricIdentifier(TCPMetric{
+		SourceIP:             "10.0.0.1",
+		DestinationIP:        "10.96.0.10",
+		DestinationServiceIP: "10.96.0.10",
+		DestinationBackendIP: "10.0.1.7",
+		DestinationPort:      "443",
+		DestinationPod:       "web-1",
+		DestinationNamespace: "shop",
+		DestinationService:   "web",
+		TargetPod:            "pod",
+		TargetContainer:      "ctr",
+		TargetNamespace:      "ns",
+		TargetOwnerKind:      "Deployment",
+		TargetOwnerName:      "api",
+		Type:                 TypeSendReset,
+		State:                1,
+	})
+
+	if got := testutil.ToFloat64(TCPReset.WithLabelValues(
+		"10.0.0.1", "10.96.0.10", "10.96.0.10", "10.0.1.7", "443", "web-1", "shop", "web", "pod", "ctr", "ns", "Deployment", "api", "established", "outbound",
+	)); got != 1 {
+		t.Fatalf("expected reset counter to be 1, got %v", got)
+	}
+}
+
+func TestPromotedLabels(t *testing.T) {
+	p := parsePromotedLabels([]string{"team", " app.kubernetes.io/name", "app_kubernetes_io_name", ""}, []string{"owner/oncall"})
+
+	wantNames := []string{"target_label_team", "target_label_app_kubernetes_io_name", "target_annotation_owner_oncall"}
+	if got := p.names(); !reflect.DeepEqual(got, wantNames) {
+		t.Fatalf("names() = %v, want %v", got, wantNames)
+	}
+
+	got := p.values(TCPMetric{
+		TargetLabels:      map[string]string{"team": "checkout"},
+		TargetAnnotations: map[string]string{"owner/oncall": "payments"},
+	})
+	if want := []string{"checkout", "none", "payments"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("values() = %v, want %v", got, want)
+	}
+}
+
+func TestConfigureLabelsPromotesPodMetadata(t *testing.T) {
+	ConfigureLabels(LabelOptions{PodLabels: []string{"team"}, PodAnnotations: []string{"owner/oncall"}})
+	t.Cleanup(func() { ConfigureLabels(LabelOptions{}) })
+
+	MetricIdentifier(TCPMetric{
+		Type:              TypeRetrans,
+		TargetLabels:      map[string]string{"team": "checkout"},
+		TargetAnnotations: map[string]string{"owner/oncall": "payments"},
+	})
+
+	got, err := testutil.GatherAndCount(common.MetricsRegistry, "flow_lens_tcp_retransmit_total")
+	if err != nil || got != 1 {
+		t.Fatalf("expected one registered retransmit series, got %d (err=%v)", got, err)
+	}
+	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(
+		"", "", "none", "unknown", "", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown",
+		"checkout", "payments",
+	)); got != 1 {
+		t.Fatalf("expected the promoted series to be 1, got %v", got)
+	}
+}
+
+func TestConfigureLabelsHostProcess(t *testing.T) {
+	if got := hostProcessValues(TCPMetric{TargetProcess: "sshd"}); got != nil {
+		t.Fatalf("expected no host labels by default, got %v", got)
+	}
+
+	ConfigureLabels(LabelOptions{HostProcess: true})
+	t.Cleanup(func() { ConfigureLabels(LabelOptions{}) })
+
+	MetricIdentifier(TCPMetric{Type: TypeRetrans, TargetProcess: "sshd", TargetUnit: "sshd.service"})
+	MetricIdentifier(TCPMetric{Type: TypeRetrans, TargetPod: "web-1"})
+
+	base := []string{"", "", "none", "unknown", "", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown"}
+	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(append(base, "sshd", "sshd.service")...)); got != 1 {
+		t.Fatalf("expected the host process series to be 1, got %v", got)
+	}
+	container := append([]string(nil), base...)
+	container[8] = "web-1"
+	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(append(container, "none", "none")...)); got != 1 {
+		t.Fatalf("expected none for container events, got %v", got)
+	}
+}
+
+func TestMetricIdentifierCount(t *testing.T) {
+	TCPRetransmit.Reset()
+
+	MetricIdentifier(TCPMetric{Type: TypeRetrans, Count: 7})
+
+	if got := testutil.ToFloat64(TCPRetransmit.WithLabelValues(
+		"", "", "none", "unknown", "", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown",
+	)); got != 7 {
+		t.Fatalf("expected counter to be 7, got %v", got)
+	}
+}
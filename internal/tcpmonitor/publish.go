@@ -0,0 +1,53 @@
+package tcpmonitor
+
+import "github.com/net-lens/flow-lens/internal/bus"
+
+// RetransmitEvent is published for every attributed retransmission.
+type RetransmitEvent struct {
+	TCPMetric
+}
+
+func (RetransmitEvent) Kind() string { return "tcp_retransmit" }
+
+// ResetEvent is published for every attributed RST. Direction is
+// "outbound" when the target sent it and "inbound" when it received it.
+type ResetEvent struct {
+	TCPMetric
+	Direction string `json:"direction"`
+}
+
+func (ResetEvent) Kind() string { return "tcp_reset" }
+
+// busEvent wraps metric in its typed event; nil for unknown types.
+func busEvent(metric TCPMetric) bus.Event {
+	switch metric.Type {
+	case TypeRetrans:
+		return RetransmitEvent{metric}
+	case TypeSendReset:
+		return ResetEvent{metric, "outbound"}
+	case TypeRecvReset:
+		return ResetEvent{metric, "inbound"}
+	}
+	return nil
+}
+
+// RecordMetrics is the bus subscriber behind the flow_lens_tcp_* counters.
+func RecordMetrics(evt bus.Event) {
+	switch e := evt.(type) {
+	case RetransmitEvent:
+		MetricIdentifier(e.TCPMetric)
+	case ResetEvent:
+		MetricIdentifier(e.TCPMetric)
+	}
+}
+
+// publish hands metric to the bus, or records it right away without one.
+func (m *Manager) publish(metric TCPMetric) {
+	if m.Bus == nil {
+		MetricIdentifier(metric)
+		return
+	}
+	if evt := busEvent(metric); evt != nil {
+		m.Bus.Publish(evt)
+	}
+}
@@ -0,0 +1,45 @@
+package tcpmonitor
+
+import (
+	"testing"
+
+	"github.com/net-lens/flow-lens/internal/bus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBusEvent(t *testing.T) {
+	if _, ok := busEvent(TCPMetric{Type: TypeRetrans}).(RetransmitEvent); !ok {
+		t.Fatalf("retransmits should become RetransmitEvent")
+	}
+	if e, ok := busEvent(TCPMetric{Type: TypeSendReset}).(ResetEvent); !ok || e.Direction != "outbound" {
+		t.Fatalf("sent resets should become outbound ResetEvent, got %#v", e)
+	}
+	if e, ok := busEvent(TCPMetric{Type: TypeRecvReset}).(ResetEvent); !ok || e.Direction != "inbound" {
+		t.Fatalf("received resets should become inbound ResetEvent, got %#v", e)
+	}
+	if evt := busEvent(TCPMetric{}); evt != nil {
+		t.Fatalf("unknown types should not be published, got %#v", evt)
+	}
+}
+
+func TestPublishThroughBus(t *testing.T) {
+	TCPReset.Reset()
+
+	events := bus.New()
+	var kinds []string
+	events.Subscribe("prometheus", 0, RecordMetrics)
+	events.Subscribe("kinds", 0, func(evt bus.Event) { kinds = append(kinds, evt.Kind()) })
+
+	m := &Manager{Bus: events}
+	m.publish(TCPMetric{Type: TypeRecvReset, Count: 2})
+	events.Close()
+
+	if len(kinds) != 1 || kinds[0] != "tcp_reset" {
+		t.Fatalf("kinds = %v, want [tcp_reset]", kinds)
+	}
+	if got := testutil.ToFloat64(TCPReset.WithLabelValues(
+		"", "", "none", "unknown", "", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "unknown", "inbound",
+	)); got != 2 {
+		t.Fatalf("expected reset counter to be 2, got %v", got)
+	}
+}
@@ -0,0 +1,25 @@
+package tcpmonitor
+
+import "time"
+
+// sampling holds the take_sample constants of tcp_monitor.c.
+type sampling struct {
+	ratio   uint32
+	costNs  uint64
+	burstNs uint64
+}
+
+// sampling converts SampleRatio, RateLimit and RateBurst to the kernel
+// constants.
+func (m *Manager) sampling() sampling {
+	s := sampling{ratio: max(m.SampleRatio, 1)}
+	if m.RateLimit > 0 {
+		burst := m.RateBurst
+		if burst == 0 {
+			burst = m.RateLimit
+		}
+		s.costNs = uint64(time.Second) / uint64(m.RateLimit)
+		s.burstNs = s.costNs * uint64(burst)
+	}
+	return s
+}
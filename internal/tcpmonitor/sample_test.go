@@ -0,0 +1,50 @@
+package tcpmonitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/net-lens/flow-lens/internal/enrich"
+)
+
+func TestSamplingConstants(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *Manager
+		want sampling
+	}{
+		{"off", &Manager{}, sampling{ratio: 1}},
+		{"ratio", &Manager{SampleRatio: 4}, sampling{ratio: 4}},
+		{"burst defaults to the limit", &Manager{RateLimit: 100}, sampling{ratio: 1, costNs: uint64(10 * time.Millisecond), burstNs: uint64(time.Second)}},
+		{"burst", &Manager{RateLimit: 10, RateBurst: 2}, sampling{ratio: 1, costNs: uint64(100 * time.Millisecond), burstNs: uint64(200 * time.Millisecond)}},
+	}
+	for _, tt := range tests {
+		if got := tt.m.sampling(); got != tt.want {
+			t.Fatalf("%s: sampling() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestWeightScalesCounters checks that a sampled event counts as the
+// kernel events it stands for; the kernel side sets the weight.
+func TestWeightScalesCounters(t *testing.T) {
+	TCPRetransmit.Reset()
+	m := &Manager{Enrichers: enrich.NewChain()}
+
+	for _, evt := range []Event{
+		{Type: TypeRetrans, Weight: 0}, // unsampled objects leave it 0
+		{Type: TypeRetrans, Weight: 1},
+		{Type: TypeRetrans, Weight: 4},
+	} {
+		if err := m.handleEvent(context.Background(), evt, evt.count()); err != nil {
+			t.Fatalf("handleEvent: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(TCPRetransmit); got != 6 {
+		t.Fatalf("retransmits = %v, want 6", got)
+	}
+}
@@ -1,23 +1,70 @@
 package tcpmonitor
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
-	"net"
+	"log"
+	"net/netip"
+	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 
+	"github.com/net-lens/flow-lens/internal/bus"
 	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/enrich"
 	"github.com/net-lens/flow-lens/internal/sock"
 )
 
+const dropPollInterval = 10 * time.Second
+
+// defaultEnrichers attributes the source like sock.Sock.Resolve and
+// leaves the destination alone.
+var defaultEnrichers = enrich.NewChain(enrich.PID(), enrich.Cgroup(), enrich.Kubernetes())
+
+// AttributionQueue holds events whose PID is not attributed yet.
+type AttributionQueue interface {
+	Defer(pid int, resolve func() (sock.ContainerInfo, bool), emit func(sock.ContainerInfo)) bool
+}
+
 // Manager wires together loading, attaching, and closing for the tcp monitor BPF programs.
 type Manager struct {
-	Collection         *ebpf.Collection
+	Collection *ebpf.Collection
+	// Enrichers attribute source and destination of every event; nil
+	// uses the pid, cgroup and kubernetes enrichers.
+	Enrichers *enrich.Chain
+	// Bus receives the attributed events; nil records them in the
+	// Prometheus counters directly.
+	Bus *bus.Bus
+	// Pending delays events racing their container's start, retrying the
+	// source enrichers of the chain; nil, or a chain without the pid and
+	// cgroup enrichers, records them unattributed right away.
+	Pending AttributionQueue
+	// QueuePolicy applies when event handling falls behind the kernel.
+	QueuePolicy common.QueuePolicy
+	// Aggregate counts events in a kernel map that is drained every
+	// AggregateInterval instead of streaming each event. Set before Load.
+	Aggregate         bool
+	AggregateInterval time.Duration
+	// SampleRatio emits the first of every SampleRatio events of a flow;
+	// RateLimit caps each flow at RateLimit events per second with bursts
+	// of RateBurst (defaults to RateLimit). Skipped events are carried in
+	// the weight of the next emitted one. Set before Load.
+	SampleRatio uint32
+	RateLimit   uint32
+	RateBurst   uint32
+	// Filter selects the events the kernel reports, see SetFilter.
+	Filter Filter
+	// FilterFile, when set, replaces Filter with the TCP_FILTER_* lines of
+	// the file and is re-read while running.
+	FilterFile string
+
+	filterMu   sync.Mutex
+	filterData []byte
+
 	tpV4ConnectLink    link.Link
 	tpRetransmitLink   link.Link
 	tpV4ConnectRetLink link.Link
@@ -25,27 +72,36 @@ type Manager struct {
 	tpRecvResetLink    link.Link
 }
 
-type Event struct {
-	Timestamp uint64
-
-	PID   uint32
-	State int32
-	Type  uint32
-	Netns uint32
+// Load opens the BPF object and validates that required programs exist.
+// Events go through a ring buffer where the kernel supports it and
+// through a perf event array otherwise.
+func (m *Manager) Load(objFileName string) error {
+	spec, err := common.LoadSpec(objFileName)
+	if err != nil {
+		return err
+	}
 
-	Sport  uint16
-	Dport  uint16
-	Family uint16
+	ringbuf, err := common.ConfigureRingbuf(spec, "events", "use_ringbuf")
+	if err != nil {
+		return err
+	}
+	if ringbuf {
+		log.Printf("[tcpmonitor] emitting events through the BPF ring buffer")
+	} else {
+		log.Printf("[tcpmonitor] emitting events through the perf event array")
+	}
 
-	Saddr   [4]byte
-	Daddr   [4]byte
-	SaddrV6 [16]byte
-	DaddrV6 [16]byte
-}
+	if err := m.configureSampling(spec); err != nil {
+		return err
+	}
+	if m.Aggregate {
+		if err := spec.RewriteConstants(map[string]interface{}{"aggregate": uint8(1)}); err != nil {
+			return fmt.Errorf("enable in-kernel aggregation: %w", err)
+		}
+		log.Printf("[tcpmonitor] aggregating events in the kernel")
+	}
 
-// Load opens the BPF object and validates that required programs exist.
-func (m *Manager) Load(objFileName string) error {
-	coll, err := common.LoadObjects(objFileName)
+	coll, err := common.NewCollection(spec)
 	if err != nil {
 		return err
 	}
@@ -54,8 +110,46 @@ func (m *Manager) Load(objFileName string) error {
 		coll.Close()
 		return fmt.Errorf("missing required tcp tracepoint programs in %s", objFileName)
 	}
-
 	m.Collection = coll
+
+	filter := m.Filter
+	if m.FilterFile != "" {
+		data, err := os.ReadFile(m.FilterFile)
+		if err != nil {
+			m.Close()
+			return fmt.Errorf("read filter file: %w", err)
+		}
+		if filter, err = parseFilterFile(data); err != nil {
+			m.Close()
+			return fmt.Errorf("%s: %w", m.FilterFile, err)
+		}
+		m.filterData = data
+	}
+	if err := m.SetFilter(filter); err != nil {
+		m.Close()
+		return err
+	}
+	return nil
+}
+
+// configureSampling sets the per-flow sampling and rate limit constants.
+func (m *Manager) configureSampling(spec *ebpf.CollectionSpec) error {
+	s := m.sampling()
+	consts := map[string]interface{}{}
+	if s.ratio > 1 {
+		consts["sample_ratio"] = s.ratio
+	}
+	if s.costNs > 0 {
+		consts["rate_cost_ns"] = s.costNs
+		consts["rate_burst_ns"] = s.burstNs
+	}
+	if len(consts) == 0 {
+		return nil
+	}
+	if err := spec.RewriteConstants(consts); err != nil {
+		return fmt.Errorf("configure per-flow sampling: %w", err)
+	}
+	log.Printf("[tcpmonitor] per-flow sampling 1/%d, rate limit %d/s", max(m.SampleRatio, 1), m.RateLimit)
 	return nil
 }
 
@@ -114,46 +208,109 @@ func (m *Manager) Run(ctx context.Context) error {
 	}
 	fmt.Println("TCP monitor running")
 
-	handler := func(data []byte) {
-		var evt Event
-		if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &evt); err != nil {
-			panic(err)
+	if m.FilterFile != "" {
+		go m.watchFilterFile(ctx, m.filterData)
+	}
+	if m.Aggregate {
+		return m.runAggregated(ctx)
+	}
+
+	handler := events.Handler("events", func(_ common.EventHeader, evt *Event) error {
+		return m.handleEvent(ctx, *evt, evt.count())
+	})
+	if drops := m.Collection.Maps["ringbuf_drops"]; drops != nil {
+		go common.PollDropCounter(ctx, drops, "events", dropPollInterval)
+	}
+	return common.PollEvents(ctx, m.Collection, "events", common.ReaderOptions{Policy: m.QueuePolicy}, handler)
+}
+
+// handleEvent attributes evt and records it as count kernel events.
+func (m *Manager) handleEvent(ctx context.Context, evt Event, count uint64) error {
+	e := enrich.Event{
+		PID:      int(evt.PID),
+		CgroupID: evt.Cgroup,
+		Netns:    uint64(evt.Netns),
+		SrcPort:  evt.Sport,
+		DstPort:  evt.Dport,
+	}
+	switch evt.Family {
+	case 2: // AF_INET
+		e.Src = netip.AddrFrom4(evt.Saddr)
+		e.Dst = netip.AddrFrom4(evt.Daddr)
+	case 10: // AF_INET6
+		e.Src = netip.AddrFrom16(evt.SaddrV6).Unmap()
+		e.Dst = netip.AddrFrom16(evt.DaddrV6).Unmap()
+	}
+
+	chain := m.Enrichers
+	if chain == nil {
+		chain = defaultEnrichers
+	}
+	chain.Enrich(ctx, &e)
+
+	// Only the pid and cgroup enrichers can attribute a PID later on.
+	if !e.Attributed && e.PID > 0 && m.Pending != nil && (chain.Has("pid") || chain.Has("cgroup")) {
+		retry := func() (sock.ContainerInfo, bool) {
+			again := e
+			chain.EnrichSource(ctx, &again)
+			return again.Source, again.Attributed
 		}
-		var srcIP, dstIP string
-
-		switch evt.Family {
-		case 2: // AF_INET
-			srcIP = net.IP(evt.Saddr[:]).String()
-			dstIP = net.IP(evt.Daddr[:]).String()
-		case 10: // AF_INET6
-			srcIP = net.IP(evt.SaddrV6[:]).String()
-			dstIP = net.IP(evt.DaddrV6[:]).String()
+		deferred := m.Pending.Defer(e.PID, retry, func(info sock.ContainerInfo) {
+			e.Source = info
+			m.publish(tcpMetric(e, evt, count))
+		})
+		if deferred {
+			return nil
 		}
+	}
+	m.publish(tcpMetric(e, evt, count))
+	return nil
+}
 
-		sockClient := &sock.Sock{PID: int(evt.PID)}
-		containerInfo, err := sockClient.GetContainerInfo(ctx)
-		if err != nil {
-			fmt.Printf("failed to get container info: %v\n", err)
-		}
+// tcpMetric flattens an enriched event into metric labels.
+func tcpMetric(e enrich.Event, evt Event, count uint64) TCPMetric {
+	var workload sock.Workload
+	if e.Source.Workload != nil {
+		workload = *e.Source.Workload
+	}
 
-		pod_name := containerInfo.PodName
-		container_name := containerInfo.ContainerName
-		namespace := containerInfo.Namespace
-
-		MetricIdentifier(TCPMetric{
-			SourceIP:        srcIP,
-			DestinationIP:   dstIP,
-			SourcePort:      strconv.Itoa(int(evt.Sport)),
-			DestinationPort: strconv.Itoa(int(evt.Dport)),
-			TargetPod:       pod_name,
-			TargetContainer: container_name,
-			TargetNamespace: namespace,
-			Type:            int(evt.Type),
-			State:           int(evt.State),
-		})
+	dstIP := addrString(e.Dst)
+	backendIP := e.Translation.BackendIP
+	if backendIP == "" {
+		backendIP = dstIP
+	}
+
+	return TCPMetric{
+		SourceIP:             addrString(e.Src),
+		DestinationIP:        dstIP,
+		DestinationServiceIP: e.Translation.ServiceIP,
+		DestinationBackendIP: backendIP,
+		DestinationPod:       e.Destination.Pod,
+		DestinationNamespace: e.Destination.Namespace,
+		DestinationService:   e.Destination.Service,
+		DestinationName:      e.DestinationName,
+		SourcePort:           strconv.Itoa(int(evt.Sport)),
+		DestinationPort:      strconv.Itoa(int(evt.Dport)),
+		TargetPod:            e.Source.PodName,
+		TargetContainer:      e.Source.ContainerName,
+		TargetNamespace:      e.Source.Namespace,
+		TargetOwnerKind:      workload.OwnerKind,
+		TargetOwnerName:      workload.OwnerName,
+		TargetLabels:         workload.Labels,
+		TargetAnnotations:    workload.Annotations,
+		TargetProcess:        e.Source.Process,
+		TargetUnit:           e.Source.Unit,
+		Type:                 int(evt.Type),
+		State:                int(evt.State),
+		Count:                count,
+	}
+}
 
+func addrString(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
 	}
-	return common.PollPerf(m.Collection, "events", handler)
+	return a.String()
 }
 
 // Close detaches links and closes the collection.
@@ -0,0 +1,136 @@
+package tcpmonitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/net-lens/flow-lens/internal/enrich"
+	"github.com/net-lens/flow-lens/internal/sock"
+)
+
+type fakeQueue struct {
+	pids    []int
+	resolve []func() (sock.ContainerInfo, bool)
+	emit    []func(sock.ContainerInfo)
+}
+
+func (q *fakeQueue) Defer(pid int, resolve func() (sock.ContainerInfo, bool), emit func(sock.ContainerInfo)) bool {
+	q.pids = append(q.pids, pid)
+	q.resolve = append(q.resolve, resolve)
+	q.emit = append(q.emit, emit)
+	return true
+}
+
+// unknownPIDs attributes nothing until started is set.
+func unknownPIDs(started *bool) *enrich.CgroupEnricher {
+	return &enrich.CgroupEnricher{Resolve: func(pid int, _ uint64) (sock.ContainerInfo, bool) {
+		if !*started {
+			return sock.ContainerInfo{}, false
+		}
+		return sock.ContainerInfo{Namespace: "shop", PodName: "web-0", ContainerID: "abc"}, true
+	}}
+}
+
+func TestHandleEventRecordsPIDZeroRightAway(t *testing.T) {
+	TCPRetransmit.Reset()
+
+	started := false
+	pending := &fakeQueue{}
+	m := &Manager{Enrichers: enrich.NewChain(unknownPIDs(&started)), Pending: pending}
+
+	if err := m.handleEvent(context.Background(), Event{Type: TypeRetrans}, 1); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(pending.pids) != 0 {
+		t.Fatalf("expected no deferral for PID 0, got %v", pending.pids)
+	}
+	if got := testutil.CollectAndCount(TCPRetransmit); got != 1 {
+		t.Fatalf("expected the event to be recorded, got %d series", got)
+	}
+
+	if err := m.handleEvent(context.Background(), Event{Type: TypeRetrans, PID: 42}, 1); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(pending.pids) != 1 || pending.pids[0] != 42 {
+		t.Fatalf("expected the unattributed PID to be deferred, got %v", pending.pids)
+	}
+}
+
+func TestDeferredEventRetriesTheChain(t *testing.T) {
+	TCPRetransmit.Reset()
+
+	started := false
+	describes := 0
+	k8s := &enrich.KubernetesEnricher{Describe: func(info sock.ContainerInfo) sock.ContainerInfo {
+		describes++
+		info.Workload = &sock.Workload{OwnerKind: "StatefulSet", OwnerName: "web"}
+		return info
+	}}
+	pending := &fakeQueue{}
+	m := &Manager{Enrichers: enrich.NewChain(unknownPIDs(&started), k8s), Pending: pending}
+
+	if err := m.handleEvent(context.Background(), Event{Type: TypeRetrans, PID: 42}, 1); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(pending.resolve) != 1 {
+		t.Fatalf("expected the event to be deferred, got %v", pending.pids)
+	}
+	if _, ok := pending.resolve[0](); ok {
+		t.Fatalf("expected no attribution before the container started")
+	}
+
+	started = true
+	info, ok := pending.resolve[0]()
+	if !ok || info.PodName != "web-0" || info.Workload == nil || describes != 1 {
+		t.Fatalf("retry = %+v (ok=%v, describes=%d), want web-0 described by the chain", info, ok, describes)
+	}
+	pending.emit[0](info)
+	if got := testutil.CollectAndCount(TCPRetransmit); got != 1 {
+		t.Fatalf("expected the deferred event to be recorded, got %d series", got)
+	}
+}
+
+func TestHandleEventDefersOnlyWithPIDEnrichers(t *testing.T) {
+	TCPRetransmit.Reset()
+
+	netns := &enrich.NetnsEnricher{Lookup: func(uint64) (sock.ContainerInfo, bool) { return sock.ContainerInfo{}, false }}
+	pending := &fakeQueue{}
+	m := &Manager{Enrichers: enrich.NewChain(netns), Pending: pending}
+
+	if err := m.handleEvent(context.Background(), Event{Type: TypeRetrans, PID: 42, Netns: 1}, 1); err != nil {
+		t.Fatalf("handleEvent: %v", err)
+	}
+	if len(pending.pids) != 0 {
+		t.Fatalf("expected no deferral without the pid and cgroup enrichers, got %v", pending.pids)
+	}
+	if got := testutil.CollectAndCount(TCPRetransmit); got != 1 {
+		t.Fatalf("expected the event to be recorded, got %d series", got)
+	}
+}
+
+// TestObjectMatchesSource catches a tcp_monitor.o built before the events
+// map became a ring buffer; make ebpf rebuilds it.
+func TestObjectMatchesSource(t *testing.T) {
+	if _, err := os.Stat(tcpMonitorObject); errors.Is(err, os.ErrNotExist) {
+		t.Skipf("%s not built, run make ebpf", tcpMonitorObject)
+	}
+
+	spec, err := ebpf.LoadCollectionSpec(tcpMonitorObject)
+	if err != nil {
+		t.Fatalf("load %s: %v", tcpMonitorObject, err)
+	}
+	if events := spec.Maps["events"]; events == nil || events.Type != ebpf.RingBuf {
+		t.Fatalf("%s: events is not a ring buffer, rebuild it with make ebpf", tcpMonitorObject)
+	}
+	var useRingbuf *btf.Var
+	if err := spec.Types.TypeByName("use_ringbuf", &useRingbuf); err != nil {
+		t.Fatalf("%s: no use_ringbuf constant, rebuild it with make ebpf: %v", tcpMonitorObject, err)
+	}
+}
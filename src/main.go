@@ -3,15 +3,26 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/net-lens/flow-lens/internal/bus"
 	"github.com/net-lens/flow-lens/internal/common"
+	"github.com/net-lens/flow-lens/internal/conntrack"
+	"github.com/net-lens/flow-lens/internal/egressmonitor"
+	"github.com/net-lens/flow-lens/internal/enrich"
+	"github.com/net-lens/flow-lens/internal/kube"
+	"github.com/net-lens/flow-lens/internal/peer"
+	"github.com/net-lens/flow-lens/internal/proctracker"
+	"github.com/net-lens/flow-lens/internal/qdiscmonitor"
 	"github.com/net-lens/flow-lens/internal/tcpmonitor"
 
 	"github.com/cilium/ebpf"
@@ -49,15 +60,125 @@ func main() {
 		Handler: mux,
 	}
 
-	sock.InitContainerdClient()
+	// The debug endpoint exposes every PID and pod on the node, so it is
+	// off unless DEBUG_ADDR names a listener of its own.
+	var debugSrv *http.Server
+	if debugAddr := os.Getenv("DEBUG_ADDR"); debugAddr != "" {
+		debugMux := http.NewServeMux()
+		debugMux.Handle("/debug/attribution", sock.DebugHandler())
+		debugSrv = &http.Server{
+			Addr:    debugAddr,
+			Handler: debugMux,
+		}
+	}
+
+	hostProcess := os.Getenv("HOST_PROCESS_LABELS") == "true"
+	sock.SetHostProcessLabels(hostProcess)
+
+	if err := sock.InitRuntime(ctx); err != nil {
+		log.Printf("pod attribution disabled: %v", err)
+	}
+	if err := sock.InitMetadata(ctx); err != nil {
+		log.Printf("container metadata providers: %v", err)
+	}
+
+	tcpmonitor.ConfigureLabels(tcpmonitor.LabelOptions{
+		PodLabels:      strings.Split(os.Getenv("POD_LABEL_ALLOWLIST"), ","),
+		PodAnnotations: strings.Split(os.Getenv("POD_ANNOTATION_ALLOWLIST"), ","),
+		HostProcess:    hostProcess,
+	})
+
+	events := bus.New()
+	events.SubscribeBlocking("prometheus", bus.DefaultBuffer, tcpmonitor.RecordMetrics)
+	if os.Getenv("EVENT_LOG") == "true" {
+		events.Subscribe("log", bus.DefaultBuffer, bus.LogSubscriber(os.Stdout))
+	}
+
+	tcpMonitor := &tcpmonitor.Manager{Bus: events}
+	if policy, err := common.ParseQueuePolicy(os.Getenv("EVENT_QUEUE_POLICY")); err != nil {
+		log.Printf("%v, blocking instead", err)
+	} else {
+		tcpMonitor.QueuePolicy = policy
+	}
+	if err := configureSampling(tcpMonitor); err != nil {
+		log.Fatalf("tcp sampling: %v", err)
+	}
+	if tcpMonitor.FilterFile = os.Getenv("TCP_FILTER_FILE"); tcpMonitor.FilterFile == "" {
+		filter, err := tcpmonitor.ParseFilter(os.Getenv)
+		if err != nil {
+			log.Fatalf("tcp event filter: %v", err)
+		}
+		tcpMonitor.Filter = filter
+	}
+	if moduleSet(os.Getenv("AGGREGATE_MODULES"))["tcpmonitor"] {
+		tcpMonitor.Aggregate = true
+		if v := os.Getenv("AGGREGATE_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("parse AGGREGATE_INTERVAL: %v", err)
+			}
+			tcpMonitor.AggregateInterval = d
+		}
+	}
+	peerEnricher := &enrich.PeerEnricher{}
+	if os.Getenv("CONNTRACK_LOOKUP") != "false" {
+		ctResolver, err := conntrack.NewResolver()
+		if err != nil {
+			log.Printf("conntrack lookup disabled: %v", err)
+		} else {
+			defer ctResolver.Close()
+			peerEnricher.Conntrack = ctResolver
+		}
+	}
+
+	if os.Getenv("PEER_ENRICHMENT") != "false" {
+		if peers, err := startPeerResolver(ctx); err != nil {
+			log.Printf("peer enrichment disabled: %v", err)
+		} else {
+			peerEnricher.Peers = peers
+			sock.SetWorkloadSource(peers)
+		}
+	}
+
+	enrichers, err := enrichmentChain(peerEnricher)
+	if err != nil {
+		log.Fatalf("enrichers: %v", err)
+	}
+	tcpMonitor.Enrichers = enrichers
+
+	if pending, err := pendingQueue(); err != nil {
+		log.Printf("deferred attribution disabled: %v", err)
+	} else if pending != nil {
+		go pending.Run(ctx)
+		tcpMonitor.Pending = pending
+	}
+
+	// The cgroup enricher reads pid_cgroup once proctracker is loaded.
+	procTracker := &proctracker.Manager{}
+	sock.SetPIDCgroups(procTracker)
 
-	modules := []moduleSpec{
+	modules := enabledModules([]moduleSpec{
 		{
 			name: "tcpmonitor",
 			obj:  "./bpf/tcpmonitor/tcp_monitor.o",
-			mod:  &tcpmonitor.Manager{},
+			mod:  tcpMonitor,
 		},
-	}
+		{
+			name: "proctracker",
+			obj:  "./bpf/proctracker/proc_tracker.o",
+			mod:  procTracker,
+		},
+		{
+			name: "egressmonitor",
+			obj:  "./bpf/egressmonitor/egress_monitor.o",
+			mod:  &egressmonitor.Manager{Enrichers: enrichers},
+		},
+		{
+			name: "qdiscmonitor",
+			obj:  "./bpf/qdiscmonitor/qdisc_monitor.o",
+			mod:  &qdiscmonitor.Manager{Enrichers: enrichers},
+		},
+	})
 
 	for _, m := range modules {
 		if err := m.mod.Load(m.obj); err != nil {
@@ -92,6 +213,15 @@ func main() {
 		}
 	}()
 
+	if debugSrv != nil {
+		go func() {
+			log.Printf("attribution debug endpoint listening on %s/debug/attribution", debugSrv.Addr)
+			if err := debugSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("debug server failed: %v", err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	cancel()
 
@@ -99,6 +229,11 @@ func main() {
 	if err := metricsSrv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Printf("metrics server shutdown error: %v", err)
 	}
+	if debugSrv != nil {
+		if err := debugSrv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("debug server shutdown error: %v", err)
+		}
+	}
 	shutdownCancel()
 
 	for _, m := range modules {
@@ -108,4 +243,157 @@ func main() {
 	}
 
 	wg.Wait()
+	events.Close()
+}
+
+// enabledModules filters specs by the comma-separated ENABLED_MODULES
+// variable. tcpmonitor and proctracker run by default.
+func enabledModules(specs []moduleSpec) []moduleSpec {
+	enabled := os.Getenv("ENABLED_MODULES")
+	if enabled == "" {
+		enabled = "tcpmonitor,proctracker"
+	}
+
+	want := moduleSet(enabled)
+	var out []moduleSpec
+	for _, spec := range specs {
+		if want[spec.name] {
+			out = append(out, spec)
+		}
+	}
+	return out
+}
+
+// configureSampling reads TCP_SAMPLE_RATIO, TCP_RATE_LIMIT and
+// TCP_RATE_BURST into m.
+func configureSampling(m *tcpmonitor.Manager) error {
+	for _, v := range []struct {
+		name string
+		dst  *uint32
+	}{
+		{"TCP_SAMPLE_RATIO", &m.SampleRatio},
+		{"TCP_RATE_LIMIT", &m.RateLimit},
+		{"TCP_RATE_BURST", &m.RateBurst},
+	} {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", v.name, value)
+		}
+		*v.dst = uint32(n)
+	}
+	return nil
+}
+
+// enrichmentChain builds the enrichers named by ENRICHERS, in that order.
+// dns is tuned by DNS_TIMEOUT and DNS_CACHE_TTL.
+func enrichmentChain(peers *enrich.PeerEnricher) (*enrich.Chain, error) {
+	value := os.Getenv("ENRICHERS")
+	if value == "" {
+		value = enrich.DefaultEnrichers
+	}
+	names, err := enrich.ParseNames(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var enrichers []enrich.Enricher
+	for _, name := range names {
+		switch name {
+		case "pid":
+			enrichers = append(enrichers, enrich.PID())
+		case "cgroup":
+			enrichers = append(enrichers, enrich.Cgroup())
+		case "netns":
+			enrichers = append(enrichers, enrich.Netns())
+		case "interface":
+			enrichers = append(enrichers, enrich.Interface())
+		case "kubernetes":
+			enrichers = append(enrichers, enrich.Kubernetes())
+		case "peer":
+			enrichers = append(enrichers, peers)
+		case "dns":
+			timeout, err := envDuration("DNS_TIMEOUT", enrich.DefaultDNSTimeout)
+			if err != nil {
+				return nil, err
+			}
+			ttl, err := envDuration("DNS_CACHE_TTL", enrich.DefaultDNSTTL)
+			if err != nil {
+				return nil, err
+			}
+			enrichers = append(enrichers, enrich.DNS(timeout, ttl))
+		}
+	}
+	log.Printf("enrichers: %s", strings.Join(names, ","))
+	return enrich.NewChain(enrichers...), nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// moduleSet parses a comma-separated list of module names.
+func moduleSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// startPeerResolver starts the Kubernetes informers backing the
+// destination_pod/namespace/service labels.
+func startPeerResolver(ctx context.Context) (*peer.Resolver, error) {
+	client, err := kube.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := peer.NewResolver(client)
+	if err != nil {
+		return nil, err
+	}
+	if err := peers.Start(ctx); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// pendingQueue builds the queue for events racing their container's start
+// from ATTRIBUTION_GRACE (a duration, "0" disables it) and
+// ATTRIBUTION_QUEUE_SIZE.
+func pendingQueue() (*sock.PendingQueue, error) {
+	grace := sock.DefaultPendingGrace
+	if v := os.Getenv("ATTRIBUTION_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse ATTRIBUTION_GRACE: %w", err)
+		}
+		grace = d
+	}
+	if grace <= 0 {
+		return nil, nil
+	}
+
+	size := sock.DefaultPendingSize
+	if v := os.Getenv("ATTRIBUTION_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid ATTRIBUTION_QUEUE_SIZE %q", v)
+		}
+		size = n
+	}
+	return sock.NewPendingQueue(size, grace), nil
 }